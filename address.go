@@ -1,16 +1,59 @@
 package belajar_golang_gorm
 
-import "time"
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	AddressTypeHome     = "home"
+	AddressTypeShipping = "shipping"
+	AddressTypeBilling  = "billing"
+)
 
 type Address struct {
-	ID        int64     `gorm:"primary_key;column:id;autoIncrement"`
-	UserId    string    `gorm:"column:user_id"`
-	Address   string    `gorm:"column:address"`
-	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
-	UpdatedAt time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
-	User      User      `gorm:"foreignKey:user_id;references:id;"`
+	ID         int64     `gorm:"primary_key;column:id;autoIncrement" json:"id"`
+	UserId     string    `gorm:"column:user_id" json:"user_id"`
+	Type       string    `gorm:"column:type" json:"type"`
+	Address    string    `gorm:"column:address;size:512;serializer:encrypted" json:"address"`
+	Street     string    `gorm:"column:street" json:"street"`
+	City       string    `gorm:"column:city" json:"city"`
+	Province   string    `gorm:"column:province" json:"province"`
+	PostalCode string    `gorm:"column:postal_code" json:"postal_code"`
+	Country    string    `gorm:"column:country" json:"country"`
+	Latitude   *float64  `gorm:"column:latitude" json:"latitude,omitempty"`
+	Longitude  *float64  `gorm:"column:longitude" json:"longitude,omitempty"`
+	IsDefault  bool      `gorm:"column:is_default" json:"is_default"`
+	CreatedAt  time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime" json:"updated_at"`
+	TenantId   string    `gorm:"column:tenant_id" json:"-"`
+	User       User      `gorm:"foreignKey:user_id;references:id;" json:"-"`
 }
 
 func (a *Address) TableName() string {
 	return "addresses"
 }
+
+func (a *Address) ReadScope(actor Actor) func(db *gorm.DB) *gorm.DB {
+	return OwnedByActor("user_id", actor)
+}
+
+func (a *Address) CanWrite(actor Actor) bool {
+	return actor.Admin || a.UserId == actor.UserId
+}
+
+func (a *Address) BeforeSave(db *gorm.DB) error {
+	switch a.Type {
+	case "":
+		a.Type = AddressTypeHome
+	case AddressTypeHome, AddressTypeShipping, AddressTypeBilling:
+	default:
+		return fmt.Errorf("invalid address type %q", a.Type)
+	}
+
+	if (a.Latitude == nil) != (a.Longitude == nil) {
+		return fmt.Errorf("address latitude and longitude must be set together")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package belajar_golang_gorm
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AddressService struct {
+	db *gorm.DB
+}
+
+func NewAddressService(db *gorm.DB) *AddressService {
+	return &AddressService{db: db}
+}
+
+// Create saves the address, the first address of a user becomes the default address
+func (s *AddressService) Create(address *Address) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&Address{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", address.UserId).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+
+		address.IsDefault = count == 0
+		return tx.Create(address).Error
+	})
+}
+
+// SetDefault makes the address the only default address of its user
+func (s *AddressService) SetDefault(addressId int64) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var address Address
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&address, "id = ?", addressId).Error
+		if err != nil {
+			return err
+		}
+
+		// lock every address of the user so concurrent calls are serialized
+		var addresses []Address
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Find(&addresses, "user_id = ?", address.UserId).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&Address{}).
+			Where("user_id = ? AND id <> ? AND is_default = ?", address.UserId, address.ID, true).
+			Update("is_default", false).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&address).Update("is_default", true).Error
+	})
+}
+
+func (s *AddressService) Default(userId string) (Address, error) {
+	var address Address
+	err := s.db.Take(&address, "user_id = ? AND is_default = ?", userId, true).Error
+	return address, err
+}
+
+func (s *AddressService) FindByType(userId string, addressType string) ([]Address, error) {
+	var addresses []Address
+	err := s.db.Where("user_id = ? AND type = ?", userId, addressType).
+		Order("is_default desc, id asc").
+		Find(&addresses).Error
+	return addresses, err
+}
@@ -0,0 +1,50 @@
+package api
+
+import belajar "habibiiberahim/belajar-golang-gorm"
+
+// requestBody is the body of POST and PUT, it lists only the fields a client can
+// write so associations and columns owned by the server, like created_at or
+// like_count, can not be set through the api
+type requestBody[T any] interface {
+	model() T
+}
+
+type userRequest struct {
+	ID       string         `json:"id"`
+	Password belajar.Secret `json:"password"`
+	Name     belajar.Name   `json:"name"`
+}
+
+func (r userRequest) model() belajar.User {
+	return belajar.User{ID: r.ID, Password: r.Password, Name: r.Name}
+}
+
+type walletRequest struct {
+	ID      string `json:"id"`
+	UserId  string `json:"user_id"`
+	Balance int64  `json:"balance"`
+}
+
+func (r walletRequest) model() belajar.Wallet {
+	return belajar.Wallet{ID: r.ID, UserId: r.UserId, Balance: r.Balance}
+}
+
+type todoRequest struct {
+	UserId      string `json:"user_id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+func (r todoRequest) model() belajar.Todo {
+	return belajar.Todo{UserId: r.UserId, Title: r.Title, Description: r.Description}
+}
+
+type productRequest struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Price int64  `json:"price"`
+}
+
+func (r productRequest) model() belajar.Product {
+	return belajar.Product{ID: r.ID, Name: r.Name, Price: r.Price}
+}
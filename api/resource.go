@@ -0,0 +1,204 @@
+package api
+
+import (
+	"gorm.io/gorm"
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"net/http"
+	"strconv"
+)
+
+// resource serves json CRUD endpoints for a model T, bodies are read as R and
+// responses are written as D: GET /{name}, POST /{name}, GET /{name}/{id},
+// PUT /{name}/{id} and DELETE /{name}/{id}
+type resource[T any, R requestBody[T], D any] struct {
+	db    *gorm.DB
+	dto   func(item T) D
+	order string
+	// filters are columns that can be filtered by exact value using query parameters
+	filters []string
+	// filter is the whitelist of the filter query parameter, e.g. ?filter=id:like:user*,sort:-created_at
+	filter belajar.FilterSchema
+	// updatable are columns changed by PUT, others are kept
+	updatable []string
+	validate  func(item *T) map[string]string
+	// authorizeWrite guards POST, PUT and DELETE when it is set
+	authorizeWrite func(r *http.Request) bool
+}
+
+func (res *resource[T, R, D]) serve(w http.ResponseWriter, r *http.Request, segments []string) {
+	write := r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodDelete
+	if write && res.authorizeWrite != nil && !res.authorizeWrite(r) {
+		writeError(w, http.StatusForbidden, "forbidden", "admin token required")
+		return
+	}
+
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		res.list(w, r)
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		res.create(w, r)
+	case len(segments) == 0:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		res.get(w, r, segments[0])
+	case len(segments) == 1 && r.Method == http.MethodPut:
+		res.replace(w, r, segments[0])
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		res.delete(w, r, segments[0])
+	case len(segments) == 1:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	default:
+		notFound(w)
+	}
+}
+
+func (res *resource[T, R, D]) list(w http.ResponseWriter, r *http.Request) {
+	page, size, fields := parsePage(r)
+	if fields != nil {
+		writeValidationError(w, fields)
+		return
+	}
+
+	query := res.db.WithContext(r.Context()).Model(new(T))
+	for _, column := range res.filters {
+		if value := r.URL.Query().Get(column); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if expr := r.URL.Query().Get("filter"); expr != "" {
+		scope, err := res.filter.Scope(expr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_filter", err.Error())
+			return
+		}
+		query = query.Scopes(scope)
+	}
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	var items []T
+	err = query.Order(res.order).Limit(size).Offset((page - 1) * size).Find(&items).Error
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	data := make([]D, 0, len(items))
+	for _, item := range items {
+		data = append(data, res.dto(item))
+	}
+	writeJSON(w, http.StatusOK, PageResponse[D]{Data: data, Page: page, Size: size, Total: total})
+}
+
+func (res *resource[T, R, D]) get(w http.ResponseWriter, r *http.Request, id string) {
+	var item T
+	err := res.db.WithContext(r.Context()).Take(&item, "id = ?", id).Error
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res.dto(item))
+}
+
+func (res *resource[T, R, D]) create(w http.ResponseWriter, r *http.Request) {
+	var body R
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	item := body.model()
+	if fields := res.validate(&item); len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	err := res.db.WithContext(r.Context()).Create(&item).Error
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, res.dto(item))
+}
+
+func (res *resource[T, R, D]) replace(w http.ResponseWriter, r *http.Request, id string) {
+	var body R
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	input := body.model()
+	if fields := res.validate(&input); len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	var existing T
+	err := res.db.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Take(&existing, "id = ?", id).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&existing).Select(res.updatable).Updates(&input).Error
+		if err != nil {
+			return err
+		}
+		return tx.Take(&existing, "id = ?", id).Error
+	})
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res.dto(existing))
+}
+
+func (res *resource[T, R, D]) delete(w http.ResponseWriter, r *http.Request, id string) {
+	result := res.db.WithContext(r.Context()).Delete(new(T), "id = ?", id)
+	if result.Error != nil {
+		writeDatabaseError(w, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeDatabaseError(w, gorm.ErrRecordNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parsePage(r *http.Request) (int, int, map[string]string) {
+	page, size := 1, belajar.DefaultPageSize
+	fields := map[string]string{}
+	var err error
+
+	if value := r.URL.Query().Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			fields["page"] = "must be a positive number"
+		}
+	}
+	if value := r.URL.Query().Get("size"); value != "" {
+		size, err = strconv.Atoi(value)
+		if err != nil || size < 1 || size > belajar.MaxPageSize {
+			fields["size"] = "must be a number between 1 and " + strconv.Itoa(belajar.MaxPageSize)
+		}
+	}
+
+	if len(fields) > 0 {
+		return 0, 0, fields
+	}
+	return page, size, nil
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	for _, method := range methods {
+		w.Header().Add("Allow", method)
+	}
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+}
+
+func notFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, "not_found", "resource not found")
+}
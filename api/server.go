@@ -0,0 +1,243 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"gorm.io/gorm"
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// TenantHeader holds the tenant of the data endpoints, the gateway in front of
+// the server sets it from the authenticated client
+const TenantHeader = "X-Tenant-Id"
+
+type Server struct {
+	db        *gorm.DB
+	users     *resource[belajar.User, userRequest, belajar.UserDTO]
+	wallets   *resource[belajar.Wallet, walletRequest, belajar.WalletDTO]
+	todos     *resource[belajar.Todo, todoRequest, belajar.TodoDTO]
+	products  *resource[belajar.Product, productRequest, belajar.ProductDTO]
+	guestBook *belajar.GuestBookService
+	metrics   http.Handler
+	health    *belajar.HealthChecker
+	options   ServerOptions
+}
+
+type ServerOptions struct {
+	// AdminToken is the bearer token of the guest book moderation and wallet
+	// write endpoints, they are forbidden for everyone when it is empty
+	AdminToken string
+}
+
+func NewServer(db *gorm.DB, options ServerOptions) *Server {
+	server := &Server{
+		db:      db,
+		options: options,
+		users: &resource[belajar.User, userRequest, belajar.UserDTO]{
+			db:        db,
+			dto:       belajar.NewUserDTO,
+			order:     "created_at desc, id desc",
+			filter:    belajar.UserFilterSchema,
+			updatable: []string{"password", "first_name", "middle_name", "last_name"},
+			validate:  belajar.ValidateUser,
+		},
+		wallets: &resource[belajar.Wallet, walletRequest, belajar.WalletDTO]{
+			db:        db,
+			dto:       belajar.NewWalletDTO,
+			order:     "id asc",
+			filters:   []string{"user_id"},
+			filter:    belajar.WalletFilterSchema,
+			updatable: []string{"balance"},
+			validate:  belajar.ValidateWallet,
+		},
+		todos: &resource[belajar.Todo, todoRequest, belajar.TodoDTO]{
+			db:        db,
+			dto:       belajar.NewTodoDTO,
+			order:     "id desc",
+			filters:   []string{"user_id"},
+			filter:    belajar.TodoFilterSchema,
+			updatable: []string{"title", "description"},
+			validate:  belajar.ValidateTodo,
+		},
+		products: &resource[belajar.Product, productRequest, belajar.ProductDTO]{
+			db:       db,
+			dto:      belajar.NewProductDTO,
+			order:    "id asc",
+			filter:   belajar.ProductFilterSchema,
+			validate: belajar.ValidateProduct,
+			// price changes are kept in the price history by the Product update hooks
+			updatable: []string{"name", "price"},
+		},
+		guestBook: belajar.NewGuestBookService(db, belajar.NewKeywordSpamClassifier()),
+		metrics:   belajar.MetricsHandler(db),
+		health:    belajar.NewHealthChecker(db, belajar.HealthOptions{Replicas: belajar.ReplicasOf(db)}),
+	}
+	// balances are only changed by admins, anyone else could set their own balance
+	server.wallets.authorizeWrite = server.isAdmin
+	return server
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch segments[0] {
+	case "users", "wallets", "todos", "products", "guest-book":
+		tenant := r.Header.Get(TenantHeader)
+		if tenant == "" {
+			writeError(w, http.StatusBadRequest, "tenant_required", TenantHeader+" header required")
+			return
+		}
+		r = r.WithContext(belajar.WithTenant(r.Context(), tenant))
+	}
+
+	switch segments[0] {
+	case "users":
+		s.users.serve(w, r, segments[1:])
+	case "wallets":
+		s.wallets.serve(w, r, segments[1:])
+	case "todos":
+		s.todos.serve(w, r, segments[1:])
+	case "products":
+		s.products.serve(w, r, segments[1:])
+	case "guest-book":
+		s.serveGuestBook(w, r, segments[1:])
+	case "metrics":
+		serveGet(w, r, segments[1:], s.metrics)
+	case "healthz":
+		serveGet(w, r, segments[1:], s.health.LivenessHandler())
+	case "readyz":
+		serveGet(w, r, segments[1:], s.health.ReadinessHandler())
+	default:
+		notFound(w)
+	}
+}
+
+// serveGet serves an endpoint without sub paths answering only GET
+func serveGet(w http.ResponseWriter, r *http.Request, segments []string, handler http.Handler) {
+	if len(segments) != 0 {
+		notFound(w)
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+type guestBookRequest struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+}
+
+// serveGuestBook serves GET /guest-book, POST /guest-book and
+// POST /guest-book/{id}/approve or /guest-book/{id}/reject
+func (s *Server) serveGuestBook(w http.ResponseWriter, r *http.Request, segments []string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		s.listGuestBook(w, r)
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		s.postGuestBook(w, r)
+	case len(segments) == 0:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	case len(segments) == 2 && (segments[1] == "approve" || segments[1] == "reject"):
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if !s.isAdmin(r) {
+			writeError(w, http.StatusForbidden, "forbidden", "admin token required")
+			return
+		}
+		s.moderateGuestBook(w, r, segments[0], segments[1])
+	default:
+		notFound(w)
+	}
+}
+
+func (s *Server) listGuestBook(w http.ResponseWriter, r *http.Request) {
+	_, size, fields := parsePage(r)
+	var cursor int64
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		var err error
+		cursor, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || cursor < 1 {
+			if fields == nil {
+				fields = map[string]string{}
+			}
+			fields["cursor"] = "is invalid"
+		}
+	}
+	if fields != nil {
+		writeValidationError(w, fields)
+		return
+	}
+
+	guestBooks, next, err := s.guestBook.WithDB(s.db.WithContext(r.Context())).ListApproved(cursor, size)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	response := CursorResponse[belajar.GuestBookDTO]{Data: make([]belajar.GuestBookDTO, 0, len(guestBooks))}
+	for _, guestBook := range guestBooks {
+		response.Data = append(response.Data, belajar.NewGuestBookDTO(guestBook))
+	}
+	if next > 0 {
+		response.NextCursor = strconv.FormatInt(next, 10)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) postGuestBook(w http.ResponseWriter, r *http.Request) {
+	var request guestBookRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+
+	guestBook, err := s.guestBook.WithDB(s.db.WithContext(r.Context())).Post(request.Name, request.Email, request.Message)
+	switch {
+	case errors.Is(err, belajar.ErrInvalidEmail):
+		writeValidationError(w, map[string]string{"email": "is invalid"})
+	case errors.Is(err, belajar.ErrEmptyMessage):
+		writeValidationError(w, map[string]string{"message": "is required"})
+	case errors.Is(err, belajar.ErrGuestBookRateLimited):
+		writeError(w, http.StatusTooManyRequests, "rate_limited", err.Error())
+	case err != nil:
+		writeDatabaseError(w, err)
+	default:
+		writeJSON(w, http.StatusCreated, belajar.NewGuestBookDTO(*guestBook))
+	}
+}
+
+// isAdmin reports whether the request has the admin token as bearer token
+func (s *Server) isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || s.options.AdminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.options.AdminToken)) == 1
+}
+
+func (s *Server) moderateGuestBook(w http.ResponseWriter, r *http.Request, value string, action string) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		notFound(w)
+		return
+	}
+
+	guestBook := s.guestBook.WithDB(s.db.WithContext(r.Context()))
+	if action == "approve" {
+		err = guestBook.Approve(id)
+	} else {
+		err = guestBook.Reject(id)
+	}
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func OpenServer() *Server {
+	db, err := belajar.OpenDatabase(belajar.DefaultDSN)
+	if err != nil {
+		panic(err)
+	}
+	keyring, err := belajar.NewKeyring("test", map[string][]byte{"test": bytes.Repeat([]byte("k"), 32)})
+	if err != nil {
+		panic(err)
+	}
+	belajar.SetKeyring(keyring)
+	return NewServer(db, ServerOptions{AdminToken: adminToken})
+}
+
+const adminToken = "admin-token"
+
+var server = OpenServer()
+
+// suffix keeps ids unique between runs without truncating tables
+var suffix = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+const tenant = "api-test"
+
+func request(method string, path string, body interface{}) *httptest.ResponseRecorder {
+	return requestWithToken(method, path, body, "")
+}
+
+func requestWithToken(method string, path string, body interface{}, token string) *httptest.ResponseRecorder {
+	var reader bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&reader).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TenantHeader, tenant)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func decode[T any](t *testing.T, recorder *httptest.ResponseRecorder) T {
+	var result T
+	err := json.Unmarshal(recorder.Body.Bytes(), &result)
+	assert.Nil(t, err)
+	return result
+}
+
+func TestCreateUser(t *testing.T) {
+	response := request(http.MethodPost, "/users", map[string]interface{}{
+		"id":       "api-user-" + suffix,
+		"password": "rahasia",
+		"name":     map[string]string{"first_name": "Habibi", "last_name": "Iberahim"},
+	})
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	assert.NotContains(t, response.Body.String(), "rahasia")
+	assert.NotContains(t, response.Body.String(), "password")
+	user := decode[belajar.UserDTO](t, response)
+	assert.Equal(t, "api-user-"+suffix, user.ID)
+	assert.Equal(t, "Habibi", user.Name.FirstName)
+
+	response = request(http.MethodPost, "/users", map[string]interface{}{
+		"id":       "api-user-" + suffix,
+		"password": "rahasia",
+		"name":     map[string]string{"first_name": "Habibi"},
+	})
+	assert.Equal(t, http.StatusConflict, response.Code)
+	assert.Equal(t, "already_exists", decode[ErrorResponse](t, response).Error.Code)
+}
+
+func TestCreateUserValidation(t *testing.T) {
+	response := request(http.MethodPost, "/users", map[string]interface{}{
+		"password": "123",
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+
+	body := decode[ErrorResponse](t, response)
+	assert.Equal(t, "validation_failed", body.Error.Code)
+	assert.Equal(t, "must be at least 6 characters", body.Error.Fields["password"])
+	assert.Equal(t, "is required", body.Error.Fields["name.first_name"])
+
+	response = request(http.MethodPost, "/users", map[string]interface{}{
+		"unknown": "field",
+	})
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Equal(t, "invalid_json", decode[ErrorResponse](t, response).Error.Code)
+}
+
+func TestGetUpdateUser(t *testing.T) {
+	response := request(http.MethodGet, "/users/api-user-"+suffix, nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	response = request(http.MethodPut, "/users/api-user-"+suffix, map[string]interface{}{
+		"password": "rahasia",
+		"name":     map[string]string{"first_name": "Habibi", "middle_name": "Updated"},
+	})
+	assert.Equal(t, http.StatusOK, response.Code)
+	user := decode[belajar.UserDTO](t, response)
+	assert.Equal(t, "Updated", user.Name.MiddleName)
+	assert.Equal(t, "", user.Name.LastName)
+
+	response = request(http.MethodGet, "/users/not-found-"+suffix, nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.Equal(t, "not_found", decode[ErrorResponse](t, response).Error.Code)
+
+	response = request(http.MethodPatch, "/users/api-user-"+suffix, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+}
+
+func TestListUsers(t *testing.T) {
+	response := request(http.MethodGet, "/users?page=1&size=2", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	page := decode[PageResponse[belajar.UserDTO]](t, response)
+	assert.Equal(t, 1, page.Page)
+	assert.Equal(t, 2, page.Size)
+	assert.LessOrEqual(t, len(page.Data), 2)
+	assert.GreaterOrEqual(t, page.Total, int64(1))
+
+	response = request(http.MethodGet, "/users?size=1000", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+}
+
+func TestWalletAndTodo(t *testing.T) {
+	userId := "api-user-" + suffix
+	wallet := map[string]interface{}{
+		"id":      "api-wallet-" + suffix,
+		"user_id": userId,
+		"balance": 500000,
+	}
+	response := request(http.MethodPost, "/wallets", wallet)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	response = requestWithToken(http.MethodPost, "/wallets", wallet, adminToken)
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	response = requestWithToken(http.MethodPost, "/wallets", map[string]interface{}{
+		"id":      "api-wallet-unknown-" + suffix,
+		"user_id": "unknown-" + suffix,
+		"balance": 500000,
+	}, adminToken)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	// only admins can change a balance
+	wallet["balance"] = 99000000
+	response = request(http.MethodPut, "/wallets/api-wallet-"+suffix, wallet)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	response = request(http.MethodDelete, "/wallets/api-wallet-"+suffix, nil)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	response = request(http.MethodGet, "/wallets/api-wallet-"+suffix, nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, int64(500000), decode[belajar.WalletDTO](t, response).Balance)
+
+	response = request(http.MethodGet, "/wallets?user_id="+userId, nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	wallets := decode[PageResponse[belajar.WalletDTO]](t, response)
+	assert.Equal(t, int64(1), wallets.Total)
+
+	response = request(http.MethodPost, "/todos", map[string]interface{}{
+		"user_id": userId,
+		"title":   "Belajar Golang",
+	})
+	assert.Equal(t, http.StatusCreated, response.Code)
+	todo := decode[belajar.TodoDTO](t, response)
+
+	path := "/todos/" + strconv.Itoa(int(todo.ID))
+	response = request(http.MethodDelete, path, nil)
+	assert.Equal(t, http.StatusNoContent, response.Code)
+	response = request(http.MethodDelete, path, nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestCreateRejectsServerOwnedFields(t *testing.T) {
+	userId := "api-nested-" + suffix
+	response := request(http.MethodPost, "/users", map[string]interface{}{
+		"id":       userId,
+		"password": "rahasia",
+		"name":     map[string]string{"first_name": "Nested"},
+		"wallet":   map[string]interface{}{"id": "api-nested-wallet-" + suffix, "balance": 1000000},
+	})
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = request(http.MethodGet, "/users/"+userId, nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	response = request(http.MethodGet, "/wallets/api-nested-wallet-"+suffix, nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	productId := "api-product-owned-" + suffix
+	response = request(http.MethodPost, "/products", map[string]interface{}{
+		"id":         productId,
+		"name":       "Product Owned",
+		"price":      1000,
+		"like_count": 999,
+	})
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	response = request(http.MethodGet, "/products/"+productId, nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestUpdateProductPrice(t *testing.T) {
+	productId := "api-product-" + suffix
+	response := request(http.MethodPost, "/products", map[string]interface{}{
+		"id":    productId,
+		"name":  "Product API",
+		"price": 100000,
+	})
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	response = request(http.MethodPut, "/products/"+productId, map[string]interface{}{
+		"id":    productId,
+		"name":  "Product API Updated",
+		"price": 150000,
+	})
+	assert.Equal(t, http.StatusOK, response.Code)
+	product := decode[belajar.ProductDTO](t, response)
+	assert.Equal(t, "Product API Updated", product.Name)
+	assert.Equal(t, int64(150000), product.Price)
+}
+
+func TestGuestBook(t *testing.T) {
+	response := request(http.MethodPost, "/guest-book", map[string]interface{}{
+		"name":    "Habibi",
+		"email":   "api-" + suffix + "@example.com",
+		"message": "Halo dari API",
+	})
+	assert.Equal(t, http.StatusCreated, response.Code)
+	guestBook := decode[belajar.GuestBookDTO](t, response)
+
+	path := "/guest-book/" + strconv.FormatInt(guestBook.ID, 10) + "/approve"
+	response = request(http.MethodPost, path, nil)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	response = requestWithToken(http.MethodPost, path, nil, "wrong-token")
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	response = requestWithToken(http.MethodPost, path, nil, adminToken)
+	assert.Equal(t, http.StatusNoContent, response.Code)
+
+	response = request(http.MethodGet, "/guest-book?size=1", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	list := decode[CursorResponse[belajar.GuestBookDTO]](t, response)
+	assert.Equal(t, 1, len(list.Data))
+	assert.Equal(t, guestBook.ID, list.Data[0].ID)
+	assert.Equal(t, "a"+strings.Repeat("*", len(suffix)+3)+"@example.com", list.Data[0].Email)
+
+	response = request(http.MethodPost, "/guest-book", map[string]interface{}{
+		"name":    "Habibi",
+		"email":   "bukan email",
+		"message": "Halo",
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+}
+
+func TestListFilter(t *testing.T) {
+	response := request(http.MethodGet, "/users?filter="+url.QueryEscape("id:eq:api-user-"+suffix+",sort:-created_at"), nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	page := decode[PageResponse[belajar.UserDTO]](t, response)
+	assert.Equal(t, int64(1), page.Total)
+	assert.Equal(t, "api-user-"+suffix, page.Data[0].ID)
+
+	response = request(http.MethodGet, "/wallets?filter="+url.QueryEscape("balance:gte:500000,user_id:eq:api-user-"+suffix), nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, int64(1), decode[PageResponse[belajar.WalletDTO]](t, response).Total)
+
+	response = request(http.MethodGet, "/users?filter="+url.QueryEscape("password:eq:rahasia"), nil)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Equal(t, "invalid_filter", decode[ErrorResponse](t, response).Error.Code)
+}
+
+func TestMetrics(t *testing.T) {
+	response := request(http.MethodGet, "/users?size=1", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	response = request(http.MethodGet, "/metrics", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	body := response.Body.String()
+	assert.Contains(t, body, `gorm_queries_total{operation="select",status="ok",table="users"}`)
+	assert.Contains(t, body, `gorm_query_duration_seconds_count{operation="select",table="users"}`)
+	assert.Contains(t, body, "gorm_db_max_open_connections 100")
+	assert.Contains(t, body, "gorm_db_in_use_connections")
+	assert.Contains(t, body, "gorm_db_wait_duration_seconds_total")
+
+	response = request(http.MethodPost, "/metrics", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+}
+
+func TestHealthz(t *testing.T) {
+	response := request(http.MethodGet, "/healthz", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, belajar.HealthOK, decode[belajar.HealthReport](t, response).Status)
+
+	response = request(http.MethodGet, "/readyz", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	report := decode[belajar.HealthReport](t, response)
+	assert.Equal(t, belajar.HealthOK, report.Status)
+	assert.Len(t, report.Checks, 3)
+
+	response = request(http.MethodGet, "/readyz/detail", nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestTenant(t *testing.T) {
+	response := request(http.MethodPost, "/users", map[string]interface{}{
+		"id":       "api-tenant-user-" + suffix,
+		"password": "rahasia",
+		"name":     map[string]string{"first_name": "Habibi"},
+	})
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/api-tenant-user-"+suffix, nil)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "tenant_required")
+
+	req = httptest.NewRequest(http.MethodGet, "/users/api-tenant-user-"+suffix, nil)
+	req.Header.Set(TenantHeader, "other")
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"habibiiberahim/belajar-golang-gorm/api"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		dsn = belajar.DefaultDSN
+	}
+	addr := os.Getenv("ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	var replicas []string
+	if value := os.Getenv("DATABASE_REPLICA_DSN"); value != "" {
+		replicas = strings.Split(value, ",")
+	}
+
+	db, err := belajar.OpenReplicatedDatabase(dsn, replicas...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyring, err := belajar.LoadKeyringFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	belajar.SetKeyring(keyring)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           api.NewServer(db, api.ServerOptions{AdminToken: os.Getenv("ADMIN_TOKEN")}),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	log.Println("listening on", addr)
+	log.Fatal(server.ListenAndServe())
+}
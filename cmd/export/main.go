@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"gorm.io/gorm"
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// export writes a table to stdout or a file, e.g.
+//
+//	export -tenant acme -model users -format parquet -columns id,first_name -filter 'balance:gt:500000' -out users.parquet
+func main() {
+	model := flag.String("model", "", "users, wallets, todos or products")
+	format := flag.String("format", "csv", "csv, jsonl or parquet")
+	columns := flag.String("columns", "", "comma separated columns, all columns when empty")
+	redact := flag.String("redact", "", "comma separated columns written as [REDACTED]")
+	filter := flag.String("filter", "", "filter expression such as id:like:user*,sort:-created_at")
+	out := flag.String("out", "", "output file, stdout when empty")
+	tenant := flag.String("tenant", "", "tenant to export")
+	allTenants := flag.Bool("all-tenants", false, "export the rows of every tenant instead of -tenant")
+	flag.Parse()
+
+	if (*tenant == "") == !*allTenants {
+		log.Fatal("either -tenant or -all-tenants is required")
+	}
+
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		dsn = belajar.DefaultDSN
+	}
+
+	var replicas []string
+	if value := os.Getenv("DATABASE_REPLICA_DSN"); value != "" {
+		replicas = strings.Split(value, ",")
+	}
+
+	db, err := belajar.OpenReplicatedDatabase(dsn, replicas...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyring, err := belajar.LoadKeyringFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	belajar.SetKeyring(keyring)
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *allTenants {
+		ctx = belajar.WithoutTenant(ctx)
+	} else {
+		ctx = belajar.WithTenant(ctx, *tenant)
+	}
+
+	options := belajar.ExportOptions{
+		Format:  belajar.ExportFormat(*format),
+		Columns: split(*columns),
+		Redact:  split(*redact),
+	}
+	count, err := export(ctx, db, *model, *filter, w, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("exported", count, "rows")
+}
+
+func export(ctx context.Context, db *gorm.DB, model string, filter string, w io.Writer, options belajar.ExportOptions) (int64, error) {
+	var schema belajar.FilterSchema
+	switch model {
+	case "users":
+		schema = belajar.UserFilterSchema
+	case "wallets":
+		schema = belajar.WalletFilterSchema
+	case "todos":
+		schema = belajar.TodoFilterSchema
+	case "products":
+		schema = belajar.ProductFilterSchema
+	default:
+		return 0, fmt.Errorf("unknown model %q", model)
+	}
+
+	scope, err := schema.Scope(filter)
+	if err != nil {
+		return 0, err
+	}
+	query := db.Scopes(scope)
+
+	switch model {
+	case "users":
+		return belajar.Export[belajar.User](ctx, query, w, options)
+	case "wallets":
+		return belajar.Export[belajar.Wallet](ctx, query, w, options)
+	case "todos":
+		return belajar.Export[belajar.Todo](ctx, query, w, options)
+	default:
+		return belajar.Export[belajar.Product](ctx, query, w, options)
+	}
+}
+
+func split(value string) []string {
+	if value == "" {
+		return nil
+	}
+	values := strings.Split(value, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"google.golang.org/grpc"
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"habibiiberahim/belajar-golang-gorm/grpcapi"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+func main() {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		dsn = belajar.DefaultDSN
+	}
+	addr := os.Getenv("ADDR")
+	if addr == "" {
+		addr = ":9090"
+	}
+
+	var replicas []string
+	if value := os.Getenv("DATABASE_REPLICA_DSN"); value != "" {
+		replicas = strings.Split(value, ",")
+	}
+
+	db, err := belajar.OpenReplicatedDatabase(dsn, replicas...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyring, err := belajar.LoadKeyringFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	belajar.SetKeyring(keyring)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(grpcapi.TenantInterceptor))
+	grpcapi.Register(server, db)
+	log.Println("listening on", addr)
+	log.Fatal(server.Serve(listener))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"log"
+	"os"
+)
+
+// keyring adds a new key to the keyring of the encrypted columns and makes it
+// the primary key, values are encrypted with it from the next start, e.g.
+//
+//	DATABASE_MASTER_KEY=$(head -c 32 /dev/urandom | base64) keyring -file keyring.json -id 2026-10
+func main() {
+	file := flag.String("file", "keyring.json", "keyring file, created when it does not exist")
+	id := flag.String("id", "", "id of the new key, stored with every value it encrypts")
+	flag.Parse()
+
+	if *id == "" {
+		log.Fatal("-id is required")
+	}
+	masterKey, err := base64.StdEncoding.DecodeString(os.Getenv("DATABASE_MASTER_KEY"))
+	if err != nil || len(masterKey) == 0 {
+		log.Fatal("DATABASE_MASTER_KEY must be the base64 of a 32 byte key")
+	}
+
+	err = belajar.RotateKeyring(*file, masterKey, *id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("primary key of", *file, "is now", *id)
+}
@@ -0,0 +1,77 @@
+package belajar_golang_gorm
+
+import (
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"log/slog"
+	"time"
+)
+
+const DefaultDSN = "user:password@(127.0.0.1:3306)/belajar_golang_gorm" +
+	"?charset=utf8mb4&parseTime=True&loc=Local"
+
+func OpenDatabase(dsn string) (*gorm.DB, error) {
+	dialect := mysql.Open(dsn)
+	sqlLogger := NewSlogLogger(slog.Default(), SlogLoggerConfig{
+		LogLevel:      logger.Info,
+		SlowThreshold: DefaultSlowThreshold,
+	})
+	db, err := gorm.Open(dialect, &gorm.Config{
+		Logger: sqlLogger,
+		//turn off default transaction
+		SkipDefaultTransaction: true,
+		//cache prepare statement to memory
+		PrepareStmt: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//Explain slow queries in the log
+	err = db.Use(sqlLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	//Trace statements with the global OpenTelemetry providers
+	tracing, err := NewTracingPlugin(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Use(tracing)
+	if err != nil {
+		return nil, err
+	}
+
+	//Count statements and their latency for the /metrics handler
+	err = db.Use(NewMetricsCollector())
+	if err != nil {
+		return nil, err
+	}
+
+	//Scope statements to the tenant of their context, see WithTenant
+	err = db.Use(NewTenantPlugin())
+	if err != nil {
+		return nil, err
+	}
+
+	//Use custom join table for many to many relation between user and product
+	err = SetupLikeJoinTable(db)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	//Set Connection Pool Database
+	sqlDB.SetMaxOpenConns(100)
+	sqlDB.SetMaxIdleConns(100)
+	sqlDB.SetConnMaxLifetime(30 * time.Minute)
+	sqlDB.SetConnMaxIdleTime(5 * time.Minute)
+
+	return db, nil
+}
@@ -108,4 +108,139 @@ create table user_like_product(
     foreign key (product_id) references products(id)
 ) engine = InnoDB;
 
-describe user_like_product;
\ No newline at end of file
+describe user_like_product;
+
+alter table products
+    add column like_count bigint not null default 0 after price;
+
+update products set like_count = (select count(*) from user_like_product where product_id = products.id);
+
+select * from products order by like_count desc;
+
+alter table user_like_product
+    add column created_at timestamp not null default current_timestamp;
+
+alter table user_like_product
+    add column source varchar(100) not null default 'web' after product_id;
+
+describe user_like_product;
+
+create table product_prices(
+    id bigint not null auto_increment,
+    product_id varchar(100) not null,
+    price bigint not null,
+    effective_from timestamp not null,
+    effective_to timestamp null,
+    created_at timestamp not null default current_timestamp,
+    updated_at timestamp not null default current_timestamp on update current_timestamp,
+    primary key (id),
+    foreign key (product_id) references products(id),
+    index (product_id, effective_from)
+) engine = InnoDB;
+
+describe product_prices;
+
+alter table addresses
+    add column type varchar(20) not null default 'home' after user_id,
+    add column street varchar(255) null after address,
+    add column city varchar(100) null after street,
+    add column province varchar(100) null after city,
+    add column postal_code varchar(20) null after province,
+    add column country varchar(100) null after postal_code,
+    add column latitude double null after country,
+    add column longitude double null after latitude,
+    add column is_default boolean not null default false after longitude;
+
+-- only one default address per user, non default rows are null so they are not unique checked
+alter table addresses
+    add column default_user_id varchar(100) as (if(is_default, user_id, null)) stored,
+    add unique index addresses_default_user_id_unique (default_user_id);
+
+describe addresses;
+
+-- spatial index can not be created on nullable column, addresses without coordinates are stored as POINT(0, 0)
+alter table addresses
+    add column location point srid 0 as (point(coalesce(longitude, 0), coalesce(latitude, 0))) stored not null,
+    add spatial index addresses_location_index (location),
+    add index addresses_latitude_longitude_index (latitude, longitude);
+
+select id, user_id, ST_AsText(location) from addresses;
+
+create table guest_book(
+    id bigint not null auto_increment,
+    name longtext null,
+    email varchar(100) null,
+    message longtext null,
+    status varchar(20) not null default 'pending',
+    moderated_at datetime(3) null,
+    created_at datetime(3) null,
+    updated_at datetime(3) null,
+    primary key (id),
+    index guest_book_email_created_at_index (email, created_at),
+    index idx_guest_book_status (status)
+) engine = InnoDB;
+
+describe guest_book;
+alter table products
+    add fulltext index products_name_fulltext (name);
+
+alter table todos
+    add fulltext index todos_title_description_fulltext (title, description);
+
+alter table guest_book
+    add fulltext index guest_book_message_fulltext (message);
+
+show index from todos;
+
+alter table users
+    add column tenant_id varchar(100) not null default '',
+    add index users_tenant_id_index (tenant_id);
+
+alter table user_logs
+    add column tenant_id varchar(100) not null default '',
+    add index user_logs_tenant_id_index (tenant_id);
+
+alter table todos
+    add column tenant_id varchar(100) not null default '',
+    add index todos_tenant_id_index (tenant_id);
+
+alter table wallets
+    add column tenant_id varchar(100) not null default '',
+    add index wallets_tenant_id_index (tenant_id);
+
+alter table addresses
+    add column tenant_id varchar(100) not null default '',
+    add index addresses_tenant_id_index (tenant_id);
+
+alter table products
+    add column tenant_id varchar(100) not null default '',
+    add index products_tenant_id_index (tenant_id);
+
+alter table user_like_product
+    add column tenant_id varchar(100) not null default '',
+    add index user_like_product_tenant_id_index (tenant_id);
+
+alter table product_prices
+    add column tenant_id varchar(100) not null default '',
+    add index product_prices_tenant_id_index (tenant_id);
+
+alter table guest_book
+    add column tenant_id varchar(100) not null default '',
+    add index idx_guest_book_tenant_id (tenant_id);
+
+alter table addresses
+    modify address varchar(512) not null;
+
+alter table guest_book
+    modify email varchar(512) null;
+
+alter table users
+    modify first_name varchar(512) not null,
+    modify middle_name varchar(512) null,
+    modify last_name varchar(512) null;
+
+create table guest_book_rate_limits(
+    email varchar(512) not null,
+    created_at datetime(3) null,
+    primary key (email)
+) engine = InnoDB;
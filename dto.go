@@ -0,0 +1,196 @@
+package belajar_golang_gorm
+
+import (
+	"strings"
+	"time"
+)
+
+// DTOs are the only types that should be written to api responses or logs,
+// they never contain secrets and mask personal data where the model has it
+
+type NameDTO struct {
+	FirstName  string `json:"first_name"`
+	MiddleName string `json:"middle_name,omitempty"`
+	LastName   string `json:"last_name,omitempty"`
+}
+
+type UserDTO struct {
+	ID        string       `json:"id"`
+	Name      NameDTO      `json:"name"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Wallet    *WalletDTO   `json:"wallet,omitempty"`
+	Addresses []AddressDTO `json:"addresses,omitempty"`
+}
+
+type WalletDTO struct {
+	ID        string    `json:"id"`
+	UserId    string    `json:"user_id"`
+	Balance   int64     `json:"balance"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type AddressDTO struct {
+	ID         int64     `json:"id"`
+	UserId     string    `json:"user_id"`
+	Type       string    `json:"type"`
+	Address    string    `json:"address"`
+	Street     string    `json:"street,omitempty"`
+	City       string    `json:"city,omitempty"`
+	Province   string    `json:"province,omitempty"`
+	PostalCode string    `json:"postal_code,omitempty"`
+	Country    string    `json:"country,omitempty"`
+	Latitude   *float64  `json:"latitude,omitempty"`
+	Longitude  *float64  `json:"longitude,omitempty"`
+	IsDefault  bool      `json:"is_default"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type TodoDTO struct {
+	ID          uint      `json:"id"`
+	UserId      string    `json:"user_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type ProductDTO struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Price     int64     `json:"price"`
+	LikeCount int64     `json:"like_count"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type GuestBookDTO struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Message   string    `json:"message"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UserLogDTO struct {
+	ID        int    `json:"id"`
+	UserId    string `json:"user_id"`
+	Action    string `json:"action"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func NewUserDTO(user User) UserDTO {
+	dto := UserDTO{
+		ID: user.ID,
+		Name: NameDTO{
+			FirstName:  user.Name.FirstName,
+			MiddleName: user.Name.MiddleName,
+			LastName:   user.Name.LastName,
+		},
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		Addresses: MapDTOs(user.Addresses, NewAddressDTO),
+	}
+	// wallet is only set when it was preloaded or joined
+	if user.Wallet.ID != "" {
+		wallet := NewWalletDTO(user.Wallet)
+		dto.Wallet = &wallet
+	}
+	return dto
+}
+
+func NewWalletDTO(wallet Wallet) WalletDTO {
+	return WalletDTO{
+		ID:        wallet.ID,
+		UserId:    wallet.UserId,
+		Balance:   wallet.Balance,
+		CreatedAt: wallet.CreatedAt,
+		UpdatedAt: wallet.UpdatedAt,
+	}
+}
+
+func NewAddressDTO(address Address) AddressDTO {
+	return AddressDTO{
+		ID:         address.ID,
+		UserId:     address.UserId,
+		Type:       address.Type,
+		Address:    address.Address,
+		Street:     address.Street,
+		City:       address.City,
+		Province:   address.Province,
+		PostalCode: address.PostalCode,
+		Country:    address.Country,
+		Latitude:   address.Latitude,
+		Longitude:  address.Longitude,
+		IsDefault:  address.IsDefault,
+		CreatedAt:  address.CreatedAt,
+		UpdatedAt:  address.UpdatedAt,
+	}
+}
+
+func NewTodoDTO(todo Todo) TodoDTO {
+	return TodoDTO{
+		ID:          todo.ID,
+		UserId:      todo.UserId,
+		Title:       todo.Title,
+		Description: todo.Description,
+		CreatedAt:   todo.CreatedAt,
+		UpdatedAt:   todo.UpdatedAt,
+	}
+}
+
+func NewProductDTO(product Product) ProductDTO {
+	return ProductDTO{
+		ID:        product.ID,
+		Name:      product.Name,
+		Price:     product.Price,
+		LikeCount: product.LikeCount,
+		CreatedAt: product.CreatedAt,
+		UpdatedAt: product.UpdatedAt,
+	}
+}
+
+// NewGuestBookDTO masks the email because guest book messages are public
+func NewGuestBookDTO(guestBook GuestBook) GuestBookDTO {
+	return GuestBookDTO{
+		ID:        guestBook.ID,
+		Name:      guestBook.Name,
+		Email:     MaskEmail(guestBook.Email),
+		Message:   guestBook.Message,
+		Status:    guestBook.Status,
+		CreatedAt: guestBook.CreatedAt,
+	}
+}
+
+func NewUserLogDTO(userLog UserLog) UserLogDTO {
+	return UserLogDTO{
+		ID:        userLog.ID,
+		UserId:    userLog.UserId,
+		Action:    userLog.Action,
+		CreatedAt: userLog.CreatedAt,
+	}
+}
+
+// MapDTOs maps every item, it returns nil for an empty slice so omitempty fields stay hidden
+func MapDTOs[T any, D any](items []T, mapper func(T) D) []D {
+	if len(items) == 0 {
+		return nil
+	}
+	dtos := make([]D, 0, len(items))
+	for _, item := range items {
+		dtos = append(dtos, mapper(item))
+	}
+	return dtos
+}
+
+// MaskEmail keeps the first character of the local part and the domain, ex: h*****@example.com
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return redacted
+	}
+	return email[:1] + strings.Repeat("*", at-1) + email[at:]
+}
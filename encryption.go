@@ -0,0 +1,405 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// encryptedPrefix starts every ciphertext, followed by the key id and the
+// base64 of the nonce and the sealed value, ex: enc:2026-10:q1w2...
+const encryptedPrefix = "enc:"
+
+var ErrNoKeyring = errors.New("no keyring for encrypted columns")
+var ErrUnknownKey = errors.New("unknown encryption key")
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+var ErrCiphertextTooLong = errors.New("ciphertext does not fit the column")
+var ErrEncryptedColumn = errors.New("encrypted column can not be searched or sorted")
+
+// keyring registers the serializer while package variables are initialized,
+// before init functions, so a package variable can already open the database
+var keyring = func() *atomic.Pointer[Keyring] {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+	return &atomic.Pointer[Keyring]{}
+}()
+
+// Keyring holds the data keys of the encrypted columns by id. Values are
+// encrypted with the primary key and decrypted with the key of their id, so
+// old keys stay in the keyring after a rotation until every row is saved again.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+	macKeys map[string][]byte
+}
+
+// keyringFile is the json of LoadKeyring, every key is sealed with the master key
+type keyringFile struct {
+	Primary string           `json:"primary"`
+	Keys    []keyringFileKey `json:"keys"`
+}
+
+type keyringFileKey struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
+
+// NewKeyring creates a keyring of 32 byte AES-256 keys, primary encrypts new values
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{primary: primary, keys: make(map[string]cipher.AEAD, len(keys)), macKeys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, not %d", id, len(key))
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		// deterministic nonces come from a key of their own
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("deterministic nonce"))
+		k.macKeys[id] = mac.Sum(nil)
+	}
+	if _, ok := k.keys[primary]; !ok {
+		return nil, fmt.Errorf("%w: primary %q", ErrUnknownKey, primary)
+	}
+	return k, nil
+}
+
+// LoadKeyring reads a keyring file whose keys are sealed with masterKey,
+// the envelope keeps the data keys useless without the master key
+func LoadKeyring(path string, masterKey []byte) (*Keyring, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyringFile
+	if err = json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid keyring %s: %w", path, err)
+	}
+	master, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for _, key := range file.Keys {
+		keys[key.ID], err = open(master, key.Key, []byte(key.ID))
+		if err != nil {
+			return nil, fmt.Errorf("key %q of %s: %w", key.ID, path, err)
+		}
+	}
+	return NewKeyring(file.Primary, keys)
+}
+
+// LoadKeyringFromEnv loads the keyring file of DATABASE_KEYRING with the
+// base64 master key of DATABASE_MASTER_KEY, it is nil when no file is set
+func LoadKeyringFromEnv() (*Keyring, error) {
+	path := os.Getenv("DATABASE_KEYRING")
+	if path == "" {
+		return nil, nil
+	}
+	masterKey, err := base64.StdEncoding.DecodeString(os.Getenv("DATABASE_MASTER_KEY"))
+	if err != nil || len(masterKey) == 0 {
+		return nil, errors.New("DATABASE_MASTER_KEY must be the base64 of a 32 byte key")
+	}
+	return LoadKeyring(path, masterKey)
+}
+
+// WrapKey seals a data key with the master key for the keyring file
+func WrapKey(masterKey []byte, id string, key []byte) (string, error) {
+	master, err := newGCM(masterKey)
+	if err != nil {
+		return "", err
+	}
+	return seal(master, key, []byte(id), nil)
+}
+
+// RotateKeyring adds a new random key to the keyring file and makes it the
+// primary key, the file is created when it does not exist yet
+func RotateKeyring(path string, masterKey []byte, id string) error {
+	var file keyringFile
+	content, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		// the file is opened first so every key is sealed with the same master key
+		if _, err = LoadKeyring(path, masterKey); err != nil {
+			return err
+		}
+		if err = json.Unmarshal(content, &file); err != nil {
+			return err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	for _, key := range file.Keys {
+		if key.ID == id {
+			return fmt.Errorf("key %q is already in %s", id, path)
+		}
+	}
+
+	key := make([]byte, 32)
+	if _, err = rand.Read(key); err != nil {
+		return err
+	}
+	if _, err = NewKeyring(id, map[string][]byte{id: key}); err != nil {
+		return err
+	}
+	wrapped, err := WrapKey(masterKey, id, key)
+	if err != nil {
+		return err
+	}
+	file.Primary = id
+	file.Keys = append(file.Keys, keyringFileKey{ID: id, Key: wrapped})
+
+	content, err = json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0600)
+}
+
+// SetKeyring sets the keyring of the encrypted serializer, columns can not be
+// read or written before it is set
+func SetKeyring(k *Keyring) {
+	keyring.Store(k)
+}
+
+func currentKeyring() (*Keyring, error) {
+	k := keyring.Load()
+	if k == nil {
+		return nil, ErrNoKeyring
+	}
+	return k, nil
+}
+
+// Encrypt seals value with the primary key, the column is authenticated so a
+// ciphertext can not be copied to another column. A deterministic ciphertext
+// is the same for the same value and key, which leaks equal values but lets
+// the column be searched with EncryptedEquals.
+func (k *Keyring) Encrypt(column string, value string, deterministic bool) (string, error) {
+	return k.encrypt(k.primary, column, value, deterministic)
+}
+
+func (k *Keyring) encrypt(id string, column string, value string, deterministic bool) (string, error) {
+	var nonce []byte
+	if deterministic {
+		mac := hmac.New(sha256.New, k.macKeys[id])
+		mac.Write([]byte(column))
+		mac.Write([]byte{0})
+		mac.Write([]byte(value))
+		nonce = mac.Sum(nil)[:k.keys[id].NonceSize()]
+	}
+	sealed, err := seal(k.keys[id], []byte(value), []byte(column), nonce)
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + id + ":" + sealed, nil
+}
+
+// Decrypt opens a ciphertext of Encrypt with the key of its id
+func (k *Keyring) Decrypt(column string, ciphertext string) (string, error) {
+	id, sealed, ok := strings.Cut(strings.TrimPrefix(ciphertext, encryptedPrefix), ":")
+	if !ok || !strings.HasPrefix(ciphertext, encryptedPrefix) {
+		return "", ErrInvalidCiphertext
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	value, err := open(aead, sealed, []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// ciphertexts returns the deterministic ciphertext of value under every key
+func (k *Keyring) ciphertexts(column string, value string) ([]interface{}, error) {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	ciphertexts := make([]interface{}, len(ids))
+	for i, id := range ids {
+		ciphertext, err := k.encrypt(id, column, value, true)
+		if err != nil {
+			return nil, err
+		}
+		ciphertexts[i] = ciphertext
+	}
+	return ciphertexts, nil
+}
+
+// equalValues returns the values a deterministic encrypted column holds for
+// value, its ciphertexts and the plaintext of rows written before encryption
+func (k *Keyring) equalValues(column string, value string) ([]interface{}, error) {
+	values, err := k.ciphertexts(column, value)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// EncryptedEquals keeps rows where the deterministic encrypted column equals
+// value, rows encrypted with an old key of the keyring and rows written in
+// plaintext before the column was encrypted are found too
+func EncryptedEquals(column string, value string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		k, err := currentKeyring()
+		if err != nil {
+			_ = db.AddError(err)
+			return db
+		}
+		values, err := k.equalValues(column, value)
+		if err != nil {
+			_ = db.AddError(err)
+			return db
+		}
+		return db.Where(clause.IN{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Values: values})
+	}
+}
+
+// rejectEncrypted fails the statement when one of the columns of its model or
+// of a joined relation is encrypted, a LIKE, a range or an ORDER BY on their
+// ciphertexts would silently match nothing or sort by noise
+func rejectEncrypted(db *gorm.DB, columns []clause.Column) bool {
+	model := db.Statement.Model
+	if model == nil {
+		model = db.Statement.Dest
+	}
+	statement := &gorm.Statement{DB: db}
+	if model == nil || statement.Parse(model) != nil {
+		return false
+	}
+	for _, column := range columns {
+		s := statement.Schema
+		if column.Table != clause.CurrentTable {
+			relation, ok := s.Relationships.Relations[column.Table]
+			if !ok {
+				continue
+			}
+			s = relation.FieldSchema
+		}
+		if field := s.LookUpField(column.Name); field != nil {
+			if _, ok := field.Serializer.(EncryptedSerializer); ok {
+				_ = db.AddError(fmt.Errorf("%w: %s", ErrEncryptedColumn, column.Name))
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EncryptedSerializer encrypts string fields tagged with serializer:encrypted
+// using AES-GCM and the keyring of SetKeyring, add the deterministic tag to
+// search the column with EncryptedEquals, ex: gorm:"column:email;size:512;serializer:encrypted;deterministic".
+// A ciphertext is about 4/3 of the value plus 45 characters, so set the size of
+// the column and values whose ciphertext does not fit fail with ErrCiphertextTooLong.
+// Empty strings are stored as is and values without the enc: prefix are read
+// as is, so rows written before the column was encrypted stay readable.
+type EncryptedSerializer struct{}
+
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("%w: %T in %s", ErrInvalidCiphertext, dbValue, field.DBName)
+	}
+
+	if strings.HasPrefix(value, encryptedPrefix) {
+		k, err := currentKeyring()
+		if err != nil {
+			return err
+		}
+		value, err = k.Decrypt(field.DBName, value)
+		if err != nil {
+			return fmt.Errorf("decrypt %s: %w", field.DBName, err)
+		}
+	}
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("serializer encrypted only supports strings, %s is %T", field.DBName, fieldValue)
+	}
+	if value == "" {
+		return value, nil
+	}
+	k, err := currentKeyring()
+	if err != nil {
+		return nil, err
+	}
+	_, deterministic := field.TagSettings["DETERMINISTIC"]
+	ciphertext, err := k.Encrypt(field.DBName, value, deterministic)
+	if err != nil {
+		return nil, err
+	}
+	if field.Size > 0 && len(ciphertext) > field.Size {
+		return nil, fmt.Errorf("%w: %s is %d characters encrypted, the column holds %d", ErrCiphertextTooLong, field.DBName, len(ciphertext), field.Size)
+	}
+	return ciphertext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the base64 of the nonce and the sealed value, a random nonce is used when nonce is nil
+func seal(aead cipher.AEAD, value []byte, additionalData []byte, nonce []byte) (string, error) {
+	if nonce == nil {
+		nonce = make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+	}
+	sealed := aead.Seal(nonce, nonce, value, additionalData)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func open(aead cipher.AEAD, sealed string, additionalData []byte) ([]byte, error) {
+	content, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(content) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	value, err := aead.Open(nil, content[:aead.NonceSize()], content[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return value, nil
+}
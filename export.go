@@ -0,0 +1,330 @@
+package belajar_golang_gorm
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var ErrUnknownExportFormat = errors.New("unknown export format")
+var ErrUnknownExportColumn = errors.New("unknown export column")
+
+type ExportFormat string
+
+const (
+	ExportCSV     ExportFormat = "csv"
+	ExportJSONL   ExportFormat = "jsonl"
+	ExportParquet ExportFormat = "parquet"
+)
+
+// ExportOptions selects the columns by their database name, all columns of the
+// model are exported when Columns is empty. Secret fields such as User.Password
+// are always redacted, Redact adds more columns written as [REDACTED].
+type ExportOptions struct {
+	Format  ExportFormat
+	Columns []string
+	Redact  []string
+	// RowGroupSize is the number of rows buffered before a parquet row group is written
+	RowGroupSize int
+}
+
+type exportColumn struct {
+	field    *schema.Field
+	redacted bool
+}
+
+// value is the value of the field in the row, encrypted columns are exported
+// decrypted, redact them to leave them out
+func (c exportColumn) value(ctx context.Context, row reflect.Value) interface{} {
+	if _, ok := c.field.Serializer.(EncryptedSerializer); ok {
+		return c.field.ReflectValueOf(ctx, row).Interface()
+	}
+	value, _ := c.field.ValueOf(ctx, row)
+	return value
+}
+
+type exportWriter interface {
+	write(values []interface{}) error
+	close() error
+}
+
+// Export streams the rows of the query to w one at a time, the query can carry
+// any scope or filter. It returns the number of exported rows.
+func Export[T any](ctx context.Context, query *gorm.DB, w io.Writer, options ExportOptions) (int64, error) {
+	columns, err := exportColumns[T](query, options)
+	if err != nil {
+		return 0, err
+	}
+
+	var writer exportWriter
+	switch options.Format {
+	case ExportCSV:
+		writer, err = newCSVExportWriter(w, columns)
+	case ExportJSONL:
+		writer = newJSONLExportWriter(w, columns)
+	case ExportParquet:
+		writer = newParquetExportWriter(w, columns, options.RowGroupSize)
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrUnknownExportFormat, options.Format)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	it := Stream[T](ctx, query)
+	defer it.Close()
+
+	var count int64
+	values := make([]interface{}, len(columns))
+	for it.Next() {
+		row := it.Value()
+		rowValue := reflect.ValueOf(&row).Elem()
+		for i, column := range columns {
+			if column.redacted {
+				values[i] = redacted
+				continue
+			}
+			values[i] = exportValue(column.value(ctx, rowValue))
+		}
+		if err = writer.write(values); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err = it.Err(); err != nil {
+		return count, err
+	}
+	return count, writer.close()
+}
+
+func exportColumns[T any](db *gorm.DB, options ExportOptions) ([]exportColumn, error) {
+	statement := &gorm.Statement{DB: db}
+	err := statement.Parse(new(T))
+	if err != nil {
+		return nil, err
+	}
+
+	names := options.Columns
+	if len(names) == 0 {
+		names = statement.Schema.DBNames
+	}
+	columns := make([]exportColumn, len(names))
+	for i, name := range names {
+		field := statement.Schema.LookUpField(name)
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownExportColumn, name)
+		}
+		columns[i] = exportColumn{
+			field:    field,
+			redacted: field.FieldType == reflect.TypeOf(Secret("")) || slices.Contains(options.Redact, field.DBName),
+		}
+	}
+	return columns, nil
+}
+
+// exportValue unwraps pointers and valuers such as gorm.DeletedAt, nil is a null column
+func exportValue(value interface{}) interface{} {
+	if valuer, ok := value.(driver.Valuer); ok {
+		reflectValue := reflect.ValueOf(value)
+		if reflectValue.Kind() == reflect.Ptr && reflectValue.IsNil() {
+			return nil
+		}
+		value, _ = valuer.Value()
+	}
+
+	reflectValue := reflect.ValueOf(value)
+	for reflectValue.Kind() == reflect.Ptr {
+		if reflectValue.IsNil() {
+			return nil
+		}
+		reflectValue = reflectValue.Elem()
+	}
+	if !reflectValue.IsValid() {
+		return nil
+	}
+	if t, ok := reflectValue.Interface().(time.Time); ok {
+		return t
+	}
+
+	switch reflectValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflectValue.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(reflectValue.Uint())
+	case reflect.Float32, reflect.Float64:
+		return reflectValue.Float()
+	case reflect.Bool:
+		return reflectValue.Bool()
+	case reflect.String:
+		return reflectValue.String()
+	}
+	return fmt.Sprint(reflectValue.Interface())
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+	record []string
+}
+
+func newCSVExportWriter(w io.Writer, columns []exportColumn) (*csvExportWriter, error) {
+	writer := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.field.DBName
+	}
+	return &csvExportWriter{writer: writer, record: make([]string, len(columns))}, writer.Write(header)
+}
+
+func (c *csvExportWriter) write(values []interface{}) error {
+	for i, value := range values {
+		switch value := value.(type) {
+		case nil:
+			c.record[i] = ""
+		case time.Time:
+			c.record[i] = value.Format(time.RFC3339Nano)
+		case int64:
+			c.record[i] = strconv.FormatInt(value, 10)
+		case float64:
+			c.record[i] = strconv.FormatFloat(value, 'f', -1, 64)
+		default:
+			c.record[i] = fmt.Sprint(value)
+		}
+	}
+	return c.writer.Write(c.record)
+}
+
+func (c *csvExportWriter) close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// jsonlExportWriter keeps the keys in the order of the columns
+type jsonlExportWriter struct {
+	writer *bufio.Writer
+	keys   [][]byte
+}
+
+func newJSONLExportWriter(w io.Writer, columns []exportColumn) *jsonlExportWriter {
+	keys := make([][]byte, len(columns))
+	for i, column := range columns {
+		keys[i], _ = json.Marshal(column.field.DBName)
+	}
+	return &jsonlExportWriter{writer: bufio.NewWriter(w), keys: keys}
+}
+
+func (j *jsonlExportWriter) write(values []interface{}) error {
+	_ = j.writer.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			_ = j.writer.WriteByte(',')
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, _ = j.writer.Write(j.keys[i])
+		_ = j.writer.WriteByte(':')
+		_, _ = j.writer.Write(data)
+	}
+	_, err := j.writer.WriteString("}\n")
+	return err
+}
+
+func (j *jsonlExportWriter) close() error {
+	return j.writer.Flush()
+}
+
+type parquetExportWriter struct {
+	writer  *parquet.Writer
+	indexes []int
+	rows    []parquet.Row
+}
+
+func newParquetExportWriter(w io.Writer, columns []exportColumn, rowGroupSize int) *parquetExportWriter {
+	if rowGroupSize <= 0 {
+		rowGroupSize = DefaultBatchSize
+	}
+
+	group := parquet.Group{}
+	for _, column := range columns {
+		group[column.field.DBName] = parquet.Optional(parquetNode(column))
+	}
+	parquetSchema := parquet.NewSchema("export", group)
+
+	// columns of a parquet group are sorted by name, values are written in that order
+	indexes := make([]int, len(columns))
+	for i, column := range columns {
+		leaf, _ := parquetSchema.Lookup(column.field.DBName)
+		indexes[i] = leaf.ColumnIndex
+	}
+
+	writer := parquet.NewWriter(w, parquetSchema, parquet.MaxRowsPerRowGroup(int64(rowGroupSize)))
+	return &parquetExportWriter{writer: writer, indexes: indexes, rows: make([]parquet.Row, 1)}
+}
+
+func parquetNode(column exportColumn) parquet.Node {
+	if column.redacted {
+		return parquet.String()
+	}
+
+	fieldType := column.field.FieldType
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType == reflect.TypeOf(time.Time{}) || fieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+		return parquet.Timestamp(parquet.Millisecond)
+	}
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return parquet.Int(64)
+	case reflect.Float32, reflect.Float64:
+		return parquet.Leaf(parquet.DoubleType)
+	case reflect.Bool:
+		return parquet.Leaf(parquet.BooleanType)
+	}
+	return parquet.String()
+}
+
+func (p *parquetExportWriter) write(values []interface{}) error {
+	row := make(parquet.Row, len(values))
+	for i, value := range values {
+		var parquetValue parquet.Value
+		switch value := value.(type) {
+		case nil:
+			row[p.indexes[i]] = parquet.NullValue().Level(0, 0, p.indexes[i])
+			continue
+		case time.Time:
+			parquetValue = parquet.Int64Value(value.UnixMilli())
+		case int64:
+			parquetValue = parquet.Int64Value(value)
+		case float64:
+			parquetValue = parquet.DoubleValue(value)
+		case bool:
+			parquetValue = parquet.BooleanValue(value)
+		default:
+			parquetValue = parquet.ByteArrayValue([]byte(fmt.Sprint(value)))
+		}
+		row[p.indexes[i]] = parquetValue.Level(0, 1, p.indexes[i])
+	}
+
+	p.rows[0] = row
+	_, err := p.writer.WriteRows(p.rows)
+	return err
+}
+
+func (p *parquetExportWriter) close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,364 @@
+package belajar_golang_gorm
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+type FilterOp string
+
+const (
+	FilterEq   FilterOp = "eq"
+	FilterNe   FilterOp = "ne"
+	FilterGt   FilterOp = "gt"
+	FilterGte  FilterOp = "gte"
+	FilterLt   FilterOp = "lt"
+	FilterLte  FilterOp = "lte"
+	FilterLike FilterOp = "like"
+	FilterIn   FilterOp = "in"
+	FilterNull FilterOp = "null"
+)
+
+type FilterType int
+
+const (
+	FilterString FilterType = iota
+	FilterNumber
+	FilterTime
+	FilterBool
+	// FilterEncrypted is a string encrypted with the deterministic tag, it is
+	// only compared with eq, ne and in
+	FilterEncrypted
+)
+
+// Filter is the parsed form of a filter expression such as
+// "name:like:user*,balance:gt:500000,sort:-created_at"
+type Filter struct {
+	Conditions []FilterCondition
+	Sort       []FilterSort
+}
+
+// FilterCondition is a field:op:value term, values of the in operator are separated by |
+type FilterCondition struct {
+	Field  string
+	Op     FilterOp
+	Values []string
+}
+
+// FilterSort is a sort:field term, a leading - sorts descending
+type FilterSort struct {
+	Field string
+	Desc  bool
+}
+
+// FilterField maps a field name of the filter to a column. The column of a
+// relationship is written as Relation.column and the relationship is joined
+// with Joins when the field is used.
+type FilterField struct {
+	Column   string
+	Type     FilterType
+	Nullable bool
+	Sortable bool
+}
+
+// FilterSchema is the whitelist of fields a filter can use for a model,
+// fields and operators outside of it are rejected. Deterministic encrypted
+// columns are FilterEncrypted fields, other encrypted columns can not be
+// filtered or sorted, queries using them fail with ErrEncryptedColumn.
+type FilterSchema map[string]FilterField
+
+var UserFilterSchema = FilterSchema{
+	"id":         {Column: "id", Type: FilterString, Sortable: true},
+	"name":       {Column: "first_name", Type: FilterEncrypted},
+	"first_name": {Column: "first_name", Type: FilterEncrypted},
+	"last_name":  {Column: "last_name", Type: FilterEncrypted, Nullable: true},
+	"balance":    {Column: "Wallet.balance", Type: FilterNumber, Sortable: true},
+	"created_at": {Column: "created_at", Type: FilterTime, Sortable: true},
+}
+
+var WalletFilterSchema = FilterSchema{
+	"id":         {Column: "id", Type: FilterString, Sortable: true},
+	"user_id":    {Column: "user_id", Type: FilterString},
+	"balance":    {Column: "balance", Type: FilterNumber, Sortable: true},
+	"created_at": {Column: "created_at", Type: FilterTime, Sortable: true},
+}
+
+var TodoFilterSchema = FilterSchema{
+	"id":          {Column: "id", Type: FilterNumber, Sortable: true},
+	"user_id":     {Column: "user_id", Type: FilterString},
+	"title":       {Column: "title", Type: FilterString, Sortable: true},
+	"description": {Column: "description", Type: FilterString, Nullable: true},
+	"created_at":  {Column: "created_at", Type: FilterTime, Sortable: true},
+}
+
+var ProductFilterSchema = FilterSchema{
+	"id":         {Column: "id", Type: FilterString, Sortable: true},
+	"name":       {Column: "name", Type: FilterString, Sortable: true},
+	"price":      {Column: "price", Type: FilterNumber, Sortable: true},
+	"like_count": {Column: "like_count", Type: FilterNumber, Sortable: true},
+	"created_at": {Column: "created_at", Type: FilterTime, Sortable: true},
+}
+
+// ParseFilter parses comma separated terms. A literal , : | or \ inside a value
+// is escaped with a backslash.
+func ParseFilter(expr string) (*Filter, error) {
+	filter := &Filter{}
+	for _, term := range splitEscaped(expr, ',', -1) {
+		if term == "" {
+			continue
+		}
+
+		parts := splitEscaped(term, ':', 3)
+		if parts[0] == "sort" {
+			if len(parts) != 2 || parts[1] == "" || parts[1] == "-" || parts[1] == "+" {
+				return nil, fmt.Errorf("%w: sort term %q must be sort:field or sort:-field", ErrInvalidFilter, term)
+			}
+			sort := FilterSort{Field: strings.TrimPrefix(parts[1], "+")}
+			if strings.HasPrefix(parts[1], "-") {
+				sort = FilterSort{Field: parts[1][1:], Desc: true}
+			}
+			filter.Sort = append(filter.Sort, sort)
+			continue
+		}
+
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("%w: term %q must be field:op:value", ErrInvalidFilter, term)
+		}
+		condition := FilterCondition{Field: parts[0], Op: FilterOp(parts[1])}
+		if condition.Op == FilterIn {
+			condition.Values = splitEscaped(parts[2], '|', -1)
+		} else {
+			condition.Values = []string{parts[2]}
+		}
+		for i, value := range condition.Values {
+			condition.Values[i] = unescapeFilter(value)
+		}
+		filter.Conditions = append(filter.Conditions, condition)
+	}
+	return filter, nil
+}
+
+// Scope parses and compiles the filter expression
+func (s FilterSchema) Scope(expr string) (func(db *gorm.DB) *gorm.DB, error) {
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	return s.Compile(filter)
+}
+
+// Compile validates the filter against the schema and returns a scope adding
+// its conditions and order. Values are always sent as bind variables.
+func (s FilterSchema) Compile(filter *Filter) (func(db *gorm.DB) *gorm.DB, error) {
+	var joins []string
+	var columns []clause.Column
+	join := func(field FilterField) clause.Column {
+		relation, name, ok := strings.Cut(field.Column, ".")
+		column := clause.Column{Table: relation, Name: name}
+		if !ok {
+			column = clause.Column{Table: clause.CurrentTable, Name: field.Column}
+		}
+		// encrypted fields are compared with their ciphertexts
+		if field.Type != FilterEncrypted {
+			columns = append(columns, column)
+		}
+		if ok && !slices.Contains(joins, relation) {
+			joins = append(joins, relation)
+		}
+		return column
+	}
+
+	var conditions []clause.Expression
+	for _, condition := range filter.Conditions {
+		field, ok := s[condition.Field]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidFilter, condition.Field)
+		}
+		expression, err := compileCondition(join(field), field, condition)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, expression)
+	}
+
+	var orders []clause.OrderByColumn
+	for _, sort := range filter.Sort {
+		field, ok := s[sort.Field]
+		if !ok || !field.Sortable || field.Type == FilterEncrypted {
+			return nil, fmt.Errorf("%w: cannot sort by %q", ErrInvalidFilter, sort.Field)
+		}
+		orders = append(orders, clause.OrderByColumn{Column: join(field), Desc: sort.Desc})
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		if rejectEncrypted(db, columns) {
+			return db
+		}
+		for _, relation := range joins {
+			db = db.Joins(relation)
+		}
+		if len(conditions) > 0 {
+			db = db.Where(clause.And(conditions...))
+		}
+		if len(orders) > 0 {
+			db = db.Clauses(clause.OrderBy{Columns: orders})
+		}
+		return db
+	}, nil
+}
+
+func compileCondition(column clause.Column, field FilterField, condition FilterCondition) (clause.Expression, error) {
+	if condition.Op == FilterNull {
+		if !field.Nullable {
+			return nil, fmt.Errorf("%w: %q cannot be null", ErrInvalidFilter, condition.Field)
+		}
+		isNull, err := strconv.ParseBool(condition.Values[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q:null expects true or false", ErrInvalidFilter, condition.Field)
+		}
+		if isNull {
+			return clause.Eq{Column: column, Value: nil}, nil
+		}
+		return clause.Neq{Column: column, Value: nil}, nil
+	}
+
+	if !filterOpAllowed(field.Type, condition.Op) {
+		return nil, fmt.Errorf("%w: operator %q is not allowed on %q", ErrInvalidFilter, condition.Op, condition.Field)
+	}
+	if field.Type == FilterEncrypted {
+		return compileEncryptedCondition(column, condition)
+	}
+
+	values := make([]interface{}, len(condition.Values))
+	for i, value := range condition.Values {
+		if condition.Op == FilterLike {
+			values[i] = likePattern(value)
+			continue
+		}
+		parsed, err := parseFilterValue(field.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid value %q for %q", ErrInvalidFilter, value, condition.Field)
+		}
+		values[i] = parsed
+	}
+
+	switch condition.Op {
+	case FilterEq:
+		return clause.Eq{Column: column, Value: values[0]}, nil
+	case FilterNe:
+		return clause.Neq{Column: column, Value: values[0]}, nil
+	case FilterGt:
+		return clause.Gt{Column: column, Value: values[0]}, nil
+	case FilterGte:
+		return clause.Gte{Column: column, Value: values[0]}, nil
+	case FilterLt:
+		return clause.Lt{Column: column, Value: values[0]}, nil
+	case FilterLte:
+		return clause.Lte{Column: column, Value: values[0]}, nil
+	case FilterLike:
+		return clause.Like{Column: column, Value: values[0]}, nil
+	default:
+		return clause.IN{Column: column, Values: values}, nil
+	}
+}
+
+// compileEncryptedCondition compares the column with the ciphertexts of the
+// values like EncryptedEquals
+func compileEncryptedCondition(column clause.Column, condition FilterCondition) (clause.Expression, error) {
+	k, err := currentKeyring()
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, value := range condition.Values {
+		matches, err := k.equalValues(column.Name, value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, matches...)
+	}
+
+	in := clause.IN{Column: column, Values: values}
+	if condition.Op == FilterNe {
+		return clause.Not(in), nil
+	}
+	return in, nil
+}
+
+func filterOpAllowed(filterType FilterType, op FilterOp) bool {
+	switch op {
+	case FilterEq, FilterNe:
+		return true
+	case FilterIn:
+		return filterType != FilterBool
+	case FilterLike:
+		return filterType == FilterString
+	case FilterGt, FilterGte, FilterLt, FilterLte:
+		return filterType == FilterNumber || filterType == FilterTime
+	}
+	return false
+}
+
+func parseFilterValue(filterType FilterType, value string) (interface{}, error) {
+	switch filterType {
+	case FilterNumber:
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return number, nil
+		}
+		return strconv.ParseFloat(value, 64)
+	case FilterTime:
+		if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+			return date, nil
+		}
+		return time.Parse(time.RFC3339, value)
+	case FilterBool:
+		return strconv.ParseBool(value)
+	}
+	return value, nil
+}
+
+// likePattern turns the * wildcard into % and escapes the wildcards of like
+func likePattern(value string) string {
+	return strings.ReplaceAll(escapeLike(value), "*", "%")
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// splitEscaped splits s on sep not preceded by a backslash into at most n parts,
+// escapes are kept so nested separators can be split later
+func splitEscaped(s string, sep byte, n int) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == sep && (n < 0 || len(parts) < n-1) {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescapeFilter(value string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		builder.WriteByte(value[i])
+	}
+	return builder.String()
+}
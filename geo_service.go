@@ -0,0 +1,221 @@
+package belajar_golang_gorm
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"sort"
+)
+
+const earthRadiusKm = 6371.0
+
+type GeoPoint struct {
+	Latitude  float64
+	Longitude float64
+}
+
+type NearbyAddress struct {
+	Address    Address
+	DistanceKm float64
+}
+
+type NearbyUser struct {
+	User       User
+	DistanceKm float64
+}
+
+// GeoService queries addresses by coordinates. On mysql it uses the spatial
+// addresses.location column, other databases use a bounding box on
+// latitude/longitude and compute the haversine distance in go.
+type GeoService struct {
+	db      *gorm.DB
+	spatial bool
+}
+
+func NewGeoService(db *gorm.DB) *GeoService {
+	return &GeoService{db: db, spatial: db.Dialector.Name() == "mysql"}
+}
+
+// NewPortableGeoService never uses spatial functions, for databases without spatial support
+func NewPortableGeoService(db *gorm.DB) *GeoService {
+	return &GeoService{db: db, spatial: false}
+}
+
+// Within returns addresses at most radiusKm away from the point, nearest first
+func (s *GeoService) Within(point GeoPoint, radiusKm float64) ([]NearbyAddress, error) {
+	var addresses []Address
+	query := s.db.Scopes(withinBoundingBox(point, radiusKm, s.spatial))
+	if s.spatial {
+		query = query.Where("ST_Distance_Sphere(location, POINT(?, ?)) <= ?", point.Longitude, point.Latitude, radiusKm*1000).
+			Order(clause.OrderBy{Expression: clause.Expr{
+				SQL:  "ST_Distance_Sphere(location, POINT(?, ?))",
+				Vars: []interface{}{point.Longitude, point.Latitude},
+			}})
+	}
+	err := query.Find(&addresses).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var nearby []NearbyAddress
+	for _, address := range addresses {
+		distance := HaversineKm(point, GeoPoint{Latitude: *address.Latitude, Longitude: *address.Longitude})
+		if !s.spatial && distance > radiusKm {
+			continue
+		}
+		nearby = append(nearby, NearbyAddress{Address: address, DistanceKm: distance})
+	}
+	sort.SliceStable(nearby, func(i, j int) bool {
+		return nearby[i].DistanceKm < nearby[j].DistanceKm
+	})
+	return nearby, nil
+}
+
+// NearestUsers returns the k users having an address nearest to the point,
+// the distance of a user is the distance of its nearest address
+func (s *GeoService) NearestUsers(point GeoPoint, k int) ([]NearbyUser, error) {
+	var distances map[string]float64
+	var err error
+	if s.spatial {
+		distances, err = s.nearestUserDistancesSpatial(point, k)
+	} else {
+		distances, err = s.nearestUserDistances(point, k)
+	}
+	if err != nil || len(distances) == 0 {
+		return nil, err
+	}
+
+	userIds := make([]string, 0, len(distances))
+	for userId := range distances {
+		userIds = append(userIds, userId)
+	}
+	var users []User
+	err = s.db.Find(&users, "id in ?", userIds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	nearby := make([]NearbyUser, 0, len(users))
+	for _, user := range users {
+		nearby = append(nearby, NearbyUser{User: user, DistanceKm: distances[user.ID]})
+	}
+	sort.SliceStable(nearby, func(i, j int) bool {
+		if nearby[i].DistanceKm == nearby[j].DistanceKm {
+			return nearby[i].User.ID < nearby[j].User.ID
+		}
+		return nearby[i].DistanceKm < nearby[j].DistanceKm
+	})
+	if len(nearby) > k {
+		nearby = nearby[:k]
+	}
+	return nearby, nil
+}
+
+func (s *GeoService) nearestUserDistancesSpatial(point GeoPoint, k int) (map[string]float64, error) {
+	var results []struct {
+		UserId   string
+		Distance float64
+	}
+	err := s.db.Model(&Address{}).
+		Select("user_id, min(ST_Distance_Sphere(location, POINT(?, ?))) as distance", point.Longitude, point.Latitude).
+		Where("latitude IS NOT NULL AND longitude IS NOT NULL").
+		Group("user_id").
+		Order("distance asc, user_id asc").
+		Limit(k).
+		Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	distances := make(map[string]float64, len(results))
+	for _, result := range results {
+		distances[result.UserId] = result.Distance / 1000
+	}
+	return distances, nil
+}
+
+// nearestUserDistances searches in a growing bounding box until k users are found
+// inside the search radius, users outside the radius could still be nearer than
+// users found in the corners of the box so they are not counted yet
+func (s *GeoService) nearestUserDistances(point GeoPoint, k int) (map[string]float64, error) {
+	maxRadiusKm := math.Pi * earthRadiusKm
+	for radiusKm := 10.0; ; radiusKm *= 4 {
+		var addresses []Address
+		err := s.db.Select("id", "user_id", "latitude", "longitude").
+			Scopes(withinBoundingBox(point, radiusKm, false)).
+			Find(&addresses).Error
+		if err != nil {
+			return nil, err
+		}
+
+		distances := make(map[string]float64)
+		for _, address := range addresses {
+			distance := HaversineKm(point, GeoPoint{Latitude: *address.Latitude, Longitude: *address.Longitude})
+			if current, ok := distances[address.UserId]; !ok || distance < current {
+				distances[address.UserId] = distance
+			}
+		}
+
+		inside := 0
+		for _, distance := range distances {
+			if distance <= radiusKm {
+				inside++
+			}
+		}
+		if inside >= k || radiusKm >= maxRadiusKm {
+			return distances, nil
+		}
+	}
+}
+
+func withinBoundingBox(point GeoPoint, radiusKm float64, spatial bool) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("latitude IS NOT NULL AND longitude IS NOT NULL")
+
+		minLat, maxLat, minLng, maxLng, ok := BoundingBox(point, radiusKm)
+		if !ok {
+			return db
+		}
+		if spatial {
+			return db.Where("MBRContains(ST_MakeEnvelope(POINT(?, ?), POINT(?, ?)), location)",
+				minLng, minLat, maxLng, maxLat)
+		}
+		return db.Where("latitude BETWEEN ? AND ?", minLat, maxLat).
+			Where("longitude BETWEEN ? AND ?", minLng, maxLng)
+	}
+}
+
+// BoundingBox returns the smallest latitude/longitude box containing the circle around
+// the point, ok is false when the circle covers a pole or crosses the antimeridian
+func BoundingBox(point GeoPoint, radiusKm float64) (minLat float64, maxLat float64, minLng float64, maxLng float64, ok bool) {
+	angularRadius := radiusKm / earthRadiusKm
+	deltaLat := angularRadius * 180 / math.Pi
+	minLat = point.Latitude - deltaLat
+	maxLat = point.Latitude + deltaLat
+	if minLat < -90 || maxLat > 90 {
+		return 0, 0, 0, 0, false
+	}
+
+	ratio := math.Sin(angularRadius) / math.Cos(point.Latitude*math.Pi/180)
+	if ratio > 1 {
+		return 0, 0, 0, 0, false
+	}
+	deltaLng := math.Asin(ratio) * 180 / math.Pi
+	minLng = point.Longitude - deltaLng
+	maxLng = point.Longitude + deltaLng
+	if minLng < -180 || maxLng > 180 {
+		return 0, 0, 0, 0, false
+	}
+	return minLat, maxLat, minLng, maxLng, true
+}
+
+func HaversineKm(from GeoPoint, to GeoPoint) float64 {
+	lat1 := from.Latitude * math.Pi / 180
+	lat2 := to.Latitude * math.Pi / 180
+	deltaLat := (to.Latitude - from.Latitude) * math.Pi / 180
+	deltaLng := (to.Longitude - from.Longitude) * math.Pi / 180
+
+	a := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(deltaLng/2)*math.Sin(deltaLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
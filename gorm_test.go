@@ -1,45 +1,51 @@
 package belajar_golang_gorm
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
+	"log/slog"
+	"math"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func OpenConnection() *gorm.DB {
-	dialect := mysql.Open("user:password@(127.0.0.1:3306)/belajar_golang_gorm" +
-		"?charset=utf8mb4&parseTime=True&loc=Local")
-	db, err := gorm.Open(dialect, &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-		//turn off default transaction
-		SkipDefaultTransaction: true,
-		//cache prepare statement to memory
-		PrepareStmt: true,
-	})
-	if err != nil {
-		panic(err)
-	}
-
-	sqlDB, err := db.DB()
+	db, err := OpenDatabase(DefaultDSN)
 	if err != nil {
 		panic(err)
 	}
+	SetKeyring(testKeyring)
+	return db.WithContext(allTenants)
+}
 
-	//Set Connection Pool Database
-	sqlDB.SetMaxOpenConns(100)
-	sqlDB.SetMaxIdleConns(100)
-	sqlDB.SetConnMaxLifetime(30 * time.Minute)
-	sqlDB.SetConnMaxIdleTime(5 * time.Minute)
+// allTenants is the context of the tests that are not about tenants, they see
+// the rows of every tenant
+var allTenants = WithoutTenant(context.Background())
 
-	return db
-}
+var testKeyring, _ = NewKeyring("test", map[string][]byte{"test": bytes.Repeat([]byte("k"), 32)})
 
 var db = OpenConnection()
 
@@ -310,7 +316,7 @@ func TestQueryAllObjects(t *testing.T) {
 
 func TestQueryCondition(t *testing.T) {
 	var users []User
-	err := db.Where("first_name like ?", "%user%").
+	err := db.Where("id <> ?", "1").
 		Where("password = ?", "rahasia").
 		Find(&users).Error
 	assert.Nil(t, err)
@@ -319,7 +325,7 @@ func TestQueryCondition(t *testing.T) {
 
 func TestOrOperator(t *testing.T) {
 	var users []User
-	err := db.Where("first_name like ?", "%user%").
+	err := db.Where("id <> ?", "1").
 		Or("password = ?", "rahasia").
 		Find(&users).Error
 	assert.Nil(t, err)
@@ -328,7 +334,7 @@ func TestOrOperator(t *testing.T) {
 
 func TestNotOperator(t *testing.T) {
 	var users []User
-	err := db.Not("first_name like ?", "%user%").
+	err := db.Not("id <> ?", "1").
 		Where("password = ?", "rahasia").
 		Find(&users).Error
 	assert.Nil(t, err)
@@ -730,7 +736,7 @@ func TestAssociationFind(t *testing.T) {
 	assert.Nil(t, err)
 
 	var users []User
-	err = db.Model(&product).Where("users.first_name LIKE ?", "User%").Association("LikedByUsers").Find(&users)
+	err = db.Model(&product).Scopes(EncryptedEquals("first_name", "user 2")).Association("LikedByUsers").Find(&users)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(users))
 }
@@ -874,7 +880,7 @@ func TestGroupByHaving(t *testing.T) {
 }
 
 func TestContext(t *testing.T) {
-	ctx := context.Background()
+	ctx := allTenants
 
 	var users []User
 	err := db.WithContext(ctx).Find(&users).Error
@@ -882,11 +888,11 @@ func TestContext(t *testing.T) {
 	assert.Equal(t, 17, len(users))
 }
 func BrokeWalletBalance(db *gorm.DB) *gorm.DB {
-	return db.Where("balance = ?", 0)
+	return db.Scopes(BalanceBetween(0, 0))
 }
 
 func RichWalletBalance(db *gorm.DB) *gorm.DB {
-	return db.Where("balance > ?", 1000000)
+	return db.Scopes(BalanceBetween(1000001, math.MaxInt64))
 }
 
 func TestScopes(t *testing.T) {
@@ -918,3 +924,2152 @@ func TestHook(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotEqual(t, "", user.ID)
 }
+
+func TestLikeService(t *testing.T) {
+	product := Product{
+		ID:    "P002",
+		Name:  "Contoh Product 2",
+		Price: 200000,
+	}
+	err := db.Create(&product).Error
+	assert.Nil(t, err)
+
+	likeService := NewLikeService(db)
+	err = likeService.Like("1", "P002")
+	assert.Nil(t, err)
+	err = likeService.Like("1", "P002")
+	assert.Nil(t, err)
+	err = likeService.Like("2", "P002")
+	assert.Nil(t, err)
+
+	err = db.Take(&product, "id = ?", "P002").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), product.LikeCount)
+
+	err = likeService.Unlike("2", "P002")
+	assert.Nil(t, err)
+	err = likeService.Unlike("2", "P002")
+	assert.Nil(t, err)
+
+	err = db.Take(&product, "id = ?", "P002").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), product.LikeCount)
+}
+
+func TestLikeServiceToggle(t *testing.T) {
+	likeService := NewLikeService(db)
+	liked, err := likeService.Toggle("3", "P002")
+	assert.Nil(t, err)
+	assert.True(t, liked)
+
+	liked, err = likeService.Toggle("3", "P002")
+	assert.Nil(t, err)
+	assert.False(t, liked)
+
+	liked, err = likeService.IsLiked("3", "P002")
+	assert.Nil(t, err)
+	assert.False(t, liked)
+}
+
+func TestLikeServiceRanking(t *testing.T) {
+	likeService := NewLikeService(db)
+	err := likeService.Like("1", "P001")
+	assert.Nil(t, err)
+	err = likeService.Like("2", "P001")
+	assert.Nil(t, err)
+	err = likeService.RecountLikes()
+	assert.Nil(t, err)
+
+	products, err := likeService.MostLiked(10, time.Time{})
+	require.Nil(t, err)
+	require.Len(t, products, 2)
+	assert.Equal(t, "P001", products[0].ID)
+
+	products, err = likeService.MostLiked(10, time.Now().Add(-1*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(products))
+
+	products, err = likeService.AlsoLiked("P002", 10)
+	require.Nil(t, err)
+	require.Len(t, products, 1)
+	assert.Equal(t, "P001", products[0].ID)
+}
+
+func TestAssociationAppendJoinTable(t *testing.T) {
+	var user User
+	err := db.Take(&user, "id = ?", "4").Error
+	assert.Nil(t, err)
+
+	var product Product
+	err = db.Take(&product, "id = ?", "P002").Error
+	assert.Nil(t, err)
+
+	ctx := WithLikeSource(allTenants, "mobile")
+	err = db.WithContext(ctx).Model(&product).Association("LikedByUsers").Append(&user)
+	assert.Nil(t, err)
+
+	var like UserLikeProduct
+	err = db.Take(&like, "user_id = ? AND product_id = ?", "4", "P002").Error
+	assert.Nil(t, err)
+	assert.Equal(t, "mobile", like.Source)
+	assert.False(t, like.CreatedAt.IsZero())
+}
+
+func TestRecentLikes(t *testing.T) {
+	likeService := NewLikeService(db)
+	likes, err := likeService.RecentLikes("P002", 7)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(likes))
+
+	products, err := likeService.RecentlyLikedProducts("4", 7)
+	require.Nil(t, err)
+	require.Len(t, products, 1)
+	assert.Equal(t, "P002", products[0].ID)
+
+	var count int64
+	err = db.Model(&UserLikeProduct{}).Scopes(LikedInLastDays(1)).Count(&count).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), count)
+}
+
+func TestLikeCountThroughAssociation(t *testing.T) {
+	product := Product{ID: "like-1", Name: "Produk Like", Price: 1000}
+	err := db.Create(&product).Error
+	assert.Nil(t, err)
+
+	var users []User
+	err = db.Where("id IN ?", []string{"5", "6"}).Order("id asc").Find(&users).Error
+	require.Nil(t, err)
+	require.Len(t, users, 2)
+
+	err = db.Model(&product).Association("LikedByUsers").Append(&users[0], &users[1])
+	assert.Nil(t, err)
+	err = db.Model(&product).Association("LikedByUsers").Append(&users[0])
+	assert.Nil(t, err)
+	err = db.Take(&product, "id = ?", "like-1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), product.LikeCount)
+
+	err = db.Model(&product).Association("LikedByUsers").Delete(&users[0])
+	assert.Nil(t, err)
+	err = db.Take(&product, "id = ?", "like-1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), product.LikeCount)
+
+	err = db.Model(&users[0]).Association("LikeProducts").Append(&product)
+	assert.Nil(t, err)
+	err = db.Take(&product, "id = ?", "like-1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), product.LikeCount)
+
+	err = db.Model(&product).Association("LikedByUsers").Clear()
+	assert.Nil(t, err)
+	err = db.Take(&product, "id = ?", "like-1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), product.LikeCount)
+}
+
+func TestPriceHistory(t *testing.T) {
+	product := Product{
+		ID:        "P003",
+		Name:      "Contoh Product 3",
+		Price:     100000,
+		CreatedAt: time.Now().Add(-48 * time.Hour),
+	}
+	err := db.Create(&product).Error
+	assert.Nil(t, err)
+
+	priceService := NewPriceService(db)
+	tomorrow := time.Now().Add(24 * time.Hour)
+	err = priceService.SchedulePrice("P003", 200000, tomorrow)
+	assert.Nil(t, err)
+
+	err = priceService.ChangePrice("P003", 150000)
+	assert.Nil(t, err)
+
+	err = db.Take(&product, "id = ?", "P003").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(150000), product.Price)
+
+	price, err := priceService.PriceAt("P003", time.Now().Add(-24*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100000), price)
+
+	price, err = priceService.PriceAt("P003", time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(150000), price)
+
+	price, err = priceService.PriceAt("P003", tomorrow.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200000), price)
+
+	prices, err := priceService.History("P003")
+	require.Nil(t, err)
+	require.Len(t, prices, 3)
+	assert.Nil(t, prices[2].EffectiveTo)
+}
+
+func TestPriceHistoryFromUpdate(t *testing.T) {
+	product := Product{
+		ID:        "P004",
+		Name:      "Contoh Product 4",
+		Price:     100000,
+		CreatedAt: time.Now().Add(-time.Hour),
+	}
+	err := db.Create(&product).Error
+	assert.Nil(t, err)
+
+	err = db.Model(&Product{}).Where("id = ?", "P004").Update("price", 120000).Error
+	assert.Nil(t, err)
+	err = db.Model(&product).Update("name", "Contoh Product 4 Baru").Error
+	assert.Nil(t, err)
+
+	priceService := NewPriceService(db)
+	prices, err := priceService.History("P004")
+	require.Nil(t, err)
+	require.Len(t, prices, 2)
+	assert.Equal(t, int64(100000), prices[0].Price)
+	assert.Equal(t, int64(120000), prices[1].Price)
+	assert.Nil(t, prices[1].EffectiveTo)
+
+	price, err := priceService.PriceAt("P004", time.Now().Add(-30*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100000), price)
+}
+
+func TestApplyScheduledPrices(t *testing.T) {
+	err := db.Model(&Product{}).Where("id = ?", "P003").UpdateColumn("price", 0).Error
+	assert.Nil(t, err)
+
+	priceService := NewPriceService(db)
+	updated, err := priceService.ApplyScheduledPrices()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), updated)
+
+	var product Product
+	err = db.Take(&product, "id = ?", "P003").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(150000), product.Price)
+}
+
+func TestAddressSetDefault(t *testing.T) {
+	addressService := NewAddressService(db)
+	home := Address{
+		UserId:     "5",
+		Address:    "Jl. Ahmad Yani Km 5",
+		Street:     "Jl. Ahmad Yani Km 5",
+		City:       "Banjarmasin",
+		Province:   "Kalimantan Selatan",
+		PostalCode: "70234",
+		Country:    "Indonesia",
+	}
+	err := addressService.Create(&home)
+	assert.Nil(t, err)
+	assert.True(t, home.IsDefault)
+	assert.Equal(t, AddressTypeHome, home.Type)
+
+	shipping := Address{
+		UserId:  "5",
+		Type:    AddressTypeShipping,
+		Address: "Jl. A. Yani Km 36",
+		City:    "Banjarbaru",
+		Country: "Indonesia",
+	}
+	err = addressService.Create(&shipping)
+	assert.Nil(t, err)
+	assert.False(t, shipping.IsDefault)
+
+	err = addressService.SetDefault(shipping.ID)
+	assert.Nil(t, err)
+
+	address, err := addressService.Default("5")
+	assert.Nil(t, err)
+	assert.Equal(t, shipping.ID, address.ID)
+
+	var count int64
+	err = db.Model(&Address{}).Where("user_id = ? AND is_default = ?", "5", true).Count(&count).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	addresses, err := addressService.FindByType("5", AddressTypeShipping)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(addresses))
+}
+
+func TestAddressInvalidType(t *testing.T) {
+	err := db.Create(&Address{
+		UserId:  "5",
+		Type:    "office",
+		Address: "Banjarmasin",
+	}).Error
+	assert.NotNil(t, err)
+}
+
+func createGeoAddresses(t *testing.T) {
+	coordinates := map[string][2]float64{
+		"6": {-3.3194, 114.5908}, // Banjarmasin
+		"7": {-3.4572, 114.8103}, // Banjarbaru
+		"8": {-2.2136, 113.9108}, // Palangka Raya
+	}
+	for userId, coordinate := range coordinates {
+		latitude, longitude := coordinate[0], coordinate[1]
+		err := db.Create(&Address{
+			UserId:    userId,
+			Address:   "Alamat user " + userId,
+			Latitude:  &latitude,
+			Longitude: &longitude,
+		}).Error
+		assert.Nil(t, err)
+	}
+}
+
+func TestHaversine(t *testing.T) {
+	banjarmasin := GeoPoint{Latitude: -3.3194, Longitude: 114.5908}
+	banjarbaru := GeoPoint{Latitude: -3.4572, Longitude: 114.8103}
+	distance := HaversineKm(banjarmasin, banjarbaru)
+	assert.InDelta(t, 28.7, distance, 0.5)
+}
+
+func TestGeoWithin(t *testing.T) {
+	createGeoAddresses(t)
+
+	geoService := NewPortableGeoService(db)
+	banjarmasin := GeoPoint{Latitude: -3.3194, Longitude: 114.5908}
+	addresses, err := geoService.Within(banjarmasin, 50)
+	require.Nil(t, err)
+	require.Len(t, addresses, 2)
+	assert.Equal(t, "6", addresses[0].Address.UserId)
+	assert.Equal(t, "7", addresses[1].Address.UserId)
+
+	addresses, err = NewGeoService(db).Within(banjarmasin, 50)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(addresses))
+}
+
+func TestGeoNearestUsers(t *testing.T) {
+	geoService := NewPortableGeoService(db)
+	palangkaRaya := GeoPoint{Latitude: -2.2136, Longitude: 113.9108}
+	users, err := geoService.NearestUsers(palangkaRaya, 2)
+	require.Nil(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "8", users[0].User.ID)
+	assert.Equal(t, "6", users[1].User.ID)
+
+	users, err = NewGeoService(db).NearestUsers(palangkaRaya, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(users))
+}
+
+func TestGuestBookPost(t *testing.T) {
+	guestBookService := NewGuestBookService(db, NewKeywordSpamClassifier("casino", "judi"))
+	_, err := guestBookService.Post("Habibi", "bukan email", "Halo")
+	assert.Equal(t, ErrInvalidEmail, err)
+
+	guestBook, err := guestBookService.Post("Habibi", "habibi@example.com", "Halo semua")
+	assert.Nil(t, err)
+	assert.Equal(t, GuestBookStatusPending, guestBook.Status)
+
+	guestBook, err = guestBookService.Post("Spammer", "spammer@example.com", "Main casino online di http://example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, GuestBookStatusSpam, guestBook.Status)
+
+	guestBookService.MaxPosts = 2
+	_, err = guestBookService.Post("Habibi", "habibi@example.com", "Halo lagi")
+	assert.Nil(t, err)
+	_, err = guestBookService.Post("Habibi", "Habibi@Example.com", "Halo lagi lagi")
+	assert.Equal(t, ErrGuestBookRateLimited, err)
+}
+
+func TestGuestBookPostConcurrent(t *testing.T) {
+	guestBookService := NewGuestBookService(db, nil)
+	guestBookService.MaxPosts = 2
+	email := "concurrent-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "@example.com"
+
+	var wait sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			_, errs[i] = guestBookService.Post("Concurrent", email, "Pesan "+strconv.Itoa(i))
+		}(i)
+	}
+	wait.Wait()
+
+	posted := 0
+	for _, err := range errs {
+		if err == nil {
+			posted++
+		} else {
+			assert.ErrorIs(t, err, ErrGuestBookRateLimited)
+		}
+	}
+	assert.Equal(t, 2, posted)
+}
+
+func TestGuestBookModeration(t *testing.T) {
+	guestBookService := NewGuestBookService(db, nil)
+	guestBookService.MaxPosts = 10
+	for i := 0; i < 5; i++ {
+		guestBook, err := guestBookService.Post("User "+strconv.Itoa(i), "moderasi@example.com", "Pesan "+strconv.Itoa(i))
+		assert.Nil(t, err)
+		if i == 4 {
+			err = guestBookService.Reject(guestBook.ID)
+		} else {
+			err = guestBookService.Approve(guestBook.ID)
+		}
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, gorm.ErrRecordNotFound, guestBookService.Approve(-1))
+
+	guestBooks, next, err := guestBookService.ListApproved(0, 3)
+	require.Nil(t, err)
+	require.Len(t, guestBooks, 3)
+	assert.NotEqual(t, int64(0), next)
+
+	guestBooks, next, err = guestBookService.ListApproved(next, 3)
+	require.Nil(t, err)
+	require.Len(t, guestBooks, 1)
+	assert.Equal(t, "Pesan 0", guestBooks[0].Message)
+	assert.Equal(t, int64(0), next)
+}
+
+func TestSecretRedaction(t *testing.T) {
+	user := User{
+		ID:       "secret",
+		Password: "rahasia",
+		Name:     Name{FirstName: "Habibi"},
+	}
+
+	assert.NotContains(t, fmt.Sprintf("%v %+v %#v %s", user, user, user, user.Password), "rahasia")
+	bytes, err := json.Marshal(user)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(bytes), "rahasia")
+
+	bytes, err = json.Marshal(NewUserDTO(user))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(bytes), "password")
+	assert.Equal(t, "rahasia", user.Password.Reveal())
+}
+
+func TestSecretStoredAsIs(t *testing.T) {
+	var user User
+	err := db.Take(&user, "id = ? AND password = ?", "2", "rahasia").Error
+	assert.Nil(t, err)
+	assert.Equal(t, Secret("rahasia"), user.Password)
+}
+
+func TestDTO(t *testing.T) {
+	var user User
+	err := db.Preload("Wallet").Preload("Addresses").Take(&user, "id = ?", "52").Error
+	assert.Nil(t, err)
+
+	dto := NewUserDTO(user)
+	assert.Equal(t, "52", dto.ID)
+	assert.NotNil(t, dto.Wallet)
+	assert.Equal(t, len(user.Addresses), len(dto.Addresses))
+
+	guestBook := NewGuestBookDTO(GuestBook{Email: "habibi@example.com"})
+	assert.Equal(t, "h*****@example.com", guestBook.Email)
+	assert.Equal(t, "[REDACTED]", MaskEmail("bukan email"))
+}
+
+func TestKeysetPagination(t *testing.T) {
+	keyset, err := NewKeyset("created_at desc, id desc")
+	assert.Nil(t, err)
+
+	var expected []User
+	err = db.Order("created_at desc, id desc").Find(&expected).Error
+	assert.Nil(t, err)
+
+	var ids []string
+	var pages []KeysetPage
+	cursor := ""
+	for {
+		users, page, err := KeysetPaginate[User](db, keyset, cursor, 3)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(users), 3)
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		pages = append(pages, page)
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+	assert.Equal(t, len(expected), len(ids))
+	for i, user := range expected {
+		assert.Equal(t, user.ID, ids[i])
+	}
+	assert.Equal(t, "", pages[0].Prev)
+
+	// walking back with the prev cursors visits the same rows in the same order
+	var backward []string
+	cursor = pages[len(pages)-1].Prev
+	for cursor != "" {
+		users, page, err := KeysetPaginate[User](db, keyset, cursor, 3)
+		assert.Nil(t, err)
+		assert.NotEqual(t, "", page.Next)
+		var ids []string
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		backward = append(ids, backward...)
+		cursor = page.Prev
+	}
+	lastPage := (len(ids)-1)%3 + 1
+	assert.Equal(t, ids[:len(ids)-lastPage], backward)
+}
+
+func TestKeysetPaginationJoins(t *testing.T) {
+	keyset, err := NewKeyset("Wallet.balance desc, users.id asc")
+	assert.Nil(t, err)
+
+	var expected []User
+	err = db.Joins("Wallet").Where("Wallet.id IS NOT NULL").
+		Order("Wallet.balance desc, users.id asc").Find(&expected).Error
+	assert.Nil(t, err)
+
+	var users []User
+	cursor := ""
+	for {
+		page, cursors, err := KeysetPaginate[User](db.Joins("Wallet").Where("Wallet.id IS NOT NULL"), keyset, cursor, 2)
+		assert.Nil(t, err)
+		users = append(users, page...)
+		if cursors.Next == "" {
+			break
+		}
+		cursor = cursors.Next
+	}
+	assert.Equal(t, len(expected), len(users))
+	for i, user := range expected {
+		assert.Equal(t, user.ID, users[i].ID)
+		assert.Equal(t, user.Wallet.Balance, users[i].Wallet.Balance)
+	}
+}
+
+func TestKeysetPaginationNullRelation(t *testing.T) {
+	err := db.Create([]User{
+		{ID: "keyset-null-1", Password: "rahasia", Name: Name{FirstName: "Keyset"}},
+		{ID: "keyset-null-2", Password: "rahasia", Name: Name{FirstName: "Keyset"}},
+	}).Error
+	assert.Nil(t, err)
+
+	keyset, err := NewKeyset("Wallet.balance desc, users.id asc")
+	assert.Nil(t, err)
+	_, _, err = KeysetPaginate[User](db.Joins("Wallet").Where("users.id LIKE ?", "keyset-null-%"), keyset, "", 1)
+	assert.ErrorIs(t, err, ErrNullKeysetValue)
+
+	// a nil pointer relationship must not panic
+	keyset, err = NewKeyset("User.id asc, wallets.id asc")
+	assert.Nil(t, err)
+	fields, err := keyset.fields(db.Model(&Wallet{}))
+	require.Nil(t, err)
+	_, err = encodeKeysetCursor(context.Background(), fields, reflect.ValueOf(&Wallet{ID: "keyset-null"}), false)
+	assert.ErrorIs(t, err, ErrNullKeysetValue)
+}
+
+func TestKeysetInvalidCursor(t *testing.T) {
+	keyset, err := NewKeyset("id asc")
+	assert.Nil(t, err)
+
+	_, _, err = KeysetPaginate[User](db, keyset, "not a cursor", 3)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	keyset, err = NewKeyset("unknown asc")
+	assert.Nil(t, err)
+	_, _, err = KeysetPaginate[User](db, keyset, "", 3)
+	assert.ErrorIs(t, err, ErrInvalidKeysetOrder)
+
+	_, err = NewKeyset("id sideways")
+	assert.ErrorIs(t, err, ErrInvalidKeysetOrder)
+}
+
+func TestParseFilter(t *testing.T) {
+	filter, err := ParseFilter(`name:like:user*,balance:gt:500000,id:in:1|2|3,last_name:eq:a\,b,sort:-created_at,sort:id`)
+	assert.Nil(t, err)
+	assert.Equal(t, []FilterCondition{
+		{Field: "name", Op: FilterLike, Values: []string{"user*"}},
+		{Field: "balance", Op: FilterGt, Values: []string{"500000"}},
+		{Field: "id", Op: FilterIn, Values: []string{"1", "2", "3"}},
+		{Field: "last_name", Op: FilterEq, Values: []string{"a,b"}},
+	}, filter.Conditions)
+	assert.Equal(t, []FilterSort{{Field: "created_at", Desc: true}, {Field: "id"}}, filter.Sort)
+
+	_, err = ParseFilter("name:like")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+	_, err = ParseFilter("sort:-")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+}
+
+func TestFilterScope(t *testing.T) {
+	scope, err := UserFilterSchema.Scope("id:like:1*,balance:gt:500000,sort:-created_at")
+	assert.Nil(t, err)
+
+	statement := db.Session(&gorm.Session{DryRun: true}).Scopes(scope).Find(&[]User{}).Statement
+	assert.Contains(t, statement.SQL.String(), "LEFT JOIN `wallets` `Wallet`")
+	assert.Contains(t, statement.SQL.String(), "(`users`.`id` LIKE ? AND `Wallet`.`balance` > ?)")
+	assert.Contains(t, statement.SQL.String(), "ORDER BY `users`.`created_at` DESC")
+	assert.Equal(t, []interface{}{"1%", int64(500000)}, statement.Vars)
+
+	var users []User
+	err = db.Scopes(scope).Find(&users).Error
+	assert.Nil(t, err)
+	for _, user := range users {
+		assert.Greater(t, user.Wallet.Balance, int64(500000))
+	}
+
+	// values never become part of the sql
+	scope, err = UserFilterSchema.Scope("id:eq:x' OR 1=1 --,id:like:100%_off")
+	assert.Nil(t, err)
+	statement = db.Session(&gorm.Session{DryRun: true}).Scopes(scope).Find(&[]User{}).Statement
+	assert.NotContains(t, statement.SQL.String(), "OR 1=1")
+	assert.Equal(t, []interface{}{"x' OR 1=1 --", `100\%\_off`}, statement.Vars)
+}
+
+func TestFilterEncrypted(t *testing.T) {
+	scope, err := UserFilterSchema.Scope("name:eq:user 5")
+	assert.Nil(t, err)
+	statement := db.Session(&gorm.Session{DryRun: true}).Scopes(scope).Find(&[]User{}).Statement
+	assert.Contains(t, statement.SQL.String(), "`users`.`first_name` IN (?,?)")
+	require.Len(t, statement.Vars, 2)
+	assert.True(t, strings.HasPrefix(statement.Vars[0].(string), "enc:"))
+	assert.Equal(t, "user 5", statement.Vars[1])
+
+	var users []User
+	err = db.Scopes(scope).Find(&users).Error
+	require.Nil(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "5", users[0].ID)
+
+	scope, err = UserFilterSchema.Scope("first_name:in:user 5|user 6,sort:id")
+	assert.Nil(t, err)
+	users = []User{}
+	err = db.Scopes(scope).Find(&users).Error
+	require.Nil(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "6", users[1].ID)
+
+	_, err = UserFilterSchema.Scope("name:like:user*")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+	_, err = UserFilterSchema.Scope("sort:last_name")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+	_, err = UserFilterSchema.Scope("middle_name:eq:x")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+}
+
+func TestFilterWhitelist(t *testing.T) {
+	_, err := UserFilterSchema.Scope("password:eq:rahasia")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+	_, err = UserFilterSchema.Scope("balance:like:5*")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+	_, err = UserFilterSchema.Scope("balance:gt:banyak")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+	_, err = UserFilterSchema.Scope("first_name:null:true")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+	_, err = WalletFilterSchema.Scope("sort:user_id")
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+
+	scope, err := TodoFilterSchema.Scope("description:null:false,created_at:gte:2020-01-01,sort:-id")
+	assert.Nil(t, err)
+	var todos []Todo
+	err = db.Scopes(scope).Find(&todos).Error
+	assert.Nil(t, err)
+}
+
+func TestScopesWallet(t *testing.T) {
+	start := time.Now().Add(-2 * time.Second)
+	err := db.Create([]Wallet{
+		{ID: "scope-1", UserId: "9", Balance: 100000},
+		{ID: "scope-2", UserId: "9", Balance: 600000},
+		{ID: "scope-3", UserId: "9", Balance: 2000000},
+	}).Error
+	assert.Nil(t, err)
+
+	var wallets []Wallet
+	err = db.Scopes(OwnedBy("9"), BalanceBetween(500000, 1000000)).Find(&wallets).Error
+	require.Nil(t, err)
+	require.Len(t, wallets, 1)
+	assert.Equal(t, "scope-2", wallets[0].ID)
+
+	// columns are qualified with the table so the scopes still work with joins
+	wallets = []Wallet{}
+	err = db.Joins("User").
+		Scopes(OwnedBy("9"), BalanceBetween(0, 1000000), CreatedBetween(start, time.Time{})).
+		Order("wallets.id asc").
+		Find(&wallets).Error
+	require.Nil(t, err)
+	require.Len(t, wallets, 2)
+	assert.Equal(t, "9", wallets[0].User.ID)
+
+	wallets = []Wallet{}
+	err = db.Scopes(OwnedBy("9"), CreatedBetween(time.Time{}, start)).Find(&wallets).Error
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(wallets))
+}
+
+func TestScopesTodo(t *testing.T) {
+	err := db.Create([]Todo{
+		{UserId: "9", Title: "Scope belajar gorm", Description: "scopes"},
+		{UserId: "9", Title: "Scope belajar grpc"},
+		{UserId: "9", Title: "Scope lain", Description: "belajar juga"},
+		{UserId: "8", Title: "Scope belajar gorm"},
+	}).Error
+	assert.Nil(t, err)
+
+	search := Search([]string{"title", "description"}, "belajar")
+	var count int64
+	err = db.Model(&Todo{}).Scopes(OwnedBy("9"), search).Count(&count).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+
+	var todos []Todo
+	for page := 1; ; page++ {
+		var result []Todo
+		err = db.Scopes(OwnedBy("9"), search, Paginate(page, 2)).Order("id asc").Find(&result).Error
+		assert.Nil(t, err)
+		if len(result) == 0 {
+			break
+		}
+		todos = append(todos, result...)
+	}
+	require.Len(t, todos, 3)
+	assert.Equal(t, "Scope belajar gorm", todos[0].Title)
+	assert.Equal(t, "Scope lain", todos[2].Title)
+}
+
+func TestScopesAddressAndProduct(t *testing.T) {
+	err := db.Create([]Address{
+		{UserId: "9", Address: "Jl. Scope 1", City: "Martapura"},
+		{UserId: "9", Address: "Jl. Scope 2", City: "Banjarbaru"},
+	}).Error
+	assert.Nil(t, err)
+
+	// address is encrypted so only the city can be searched
+	var addresses []Address
+	err = db.Scopes(OwnedBy("9"), Search([]string{"address", "city"}, "Martapura")).Find(&addresses).Error
+	assert.ErrorIs(t, err, ErrEncryptedColumn)
+	err = db.Scopes(OwnedBy("9"), Search([]string{"city"}, "Martapura"), Paginate(1, 10)).Find(&addresses).Error
+	require.Nil(t, err)
+	require.Len(t, addresses, 1)
+	assert.Equal(t, "Jl. Scope 1", addresses[0].Address)
+
+	err = db.Create([]Product{
+		{ID: "scope-1", Name: "Scope Diskon 100%", Price: 100000},
+		{ID: "scope-2", Name: "Scope Laptop", Price: 100000},
+	}).Error
+	assert.Nil(t, err)
+
+	// wildcards in the term are searched literally
+	var products []Product
+	err = db.Scopes(Search([]string{"name"}, "100%"), CreatedBetween(time.Now().AddDate(0, 0, -1), time.Time{})).Find(&products).Error
+	require.Nil(t, err)
+	require.Len(t, products, 1)
+	assert.Equal(t, "scope-1", products[0].ID)
+
+	products = []Product{}
+	err = db.Scopes(Search([]string{"name"}, "Scope"), Paginate(0, 1000)).Order("id asc").Find(&products).Error
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(products))
+}
+
+func TestFullTextSearch(t *testing.T) {
+	err := MigrateFullText(db)
+	assert.Nil(t, err)
+
+	err = db.Create([]Product{
+		{ID: "fulltext-1", Name: "Laptop Gaming Murah", Price: 10000000},
+		{ID: "fulltext-2", Name: "Laptop Kantor", Price: 7000000},
+		{ID: "fulltext-3", Name: "Mouse Wireless", Price: 150000},
+	}).Error
+	assert.Nil(t, err)
+
+	products, err := NewProductSearch(db).Search("Laptop Gaming", 10)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, len(products), 2)
+	assert.Equal(t, "fulltext-1", products[0].Item.ID)
+	assert.Equal(t, "Laptop Gaming Murah", products[0].Item.Name)
+	for i, product := range products {
+		assert.NotEqual(t, "fulltext-3", product.Item.ID)
+		if i > 0 {
+			assert.GreaterOrEqual(t, products[i-1].Score, product.Score)
+		}
+	}
+
+	products, err = NewProductSearch(db).Search("Laptop", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(products))
+
+	products, err = NewProductSearch(db).Search("  ", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(products))
+}
+
+// fullTextDialector reports another database name, so the postgres and sqlite
+// statements can be checked with DryRun on the mysql connection
+type fullTextDialector struct {
+	gorm.Dialector
+	name string
+}
+
+func (d fullTextDialector) Name() string {
+	return d.name
+}
+
+// sqlRecorder keeps the sql of every statement with its values
+type sqlRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+func TestFullTextSearchDialects(t *testing.T) {
+	sqlDB, err := db.DB()
+	require.Nil(t, err)
+
+	expected := map[string][]string{
+		"postgres": {
+			"CREATE INDEX IF NOT EXISTS products_name_fulltext ON products USING gin ((to_tsvector('simple', coalesce(name, ''))))",
+			"SELECT `products`.`id` AS id, ts_rank(to_tsvector('simple', coalesce(name, '')), plainto_tsquery('simple', 'laptop \"gaming\"')) AS score " +
+				"FROM `products` WHERE to_tsvector('simple', coalesce(name, '')) @@ plainto_tsquery('simple', 'laptop \"gaming\"') ORDER BY score desc LIMIT 5",
+		},
+		"sqlite": {
+			"CREATE VIRTUAL TABLE IF NOT EXISTS products_fts USING fts5(name, content='products', content_rowid='rowid')",
+			"CREATE TRIGGER IF NOT EXISTS products_fts_ai AFTER INSERT ON products BEGIN " +
+				"INSERT INTO products_fts(rowid, name) VALUES (new.rowid, new.name); END",
+			"CREATE TRIGGER IF NOT EXISTS products_fts_ad AFTER DELETE ON products BEGIN " +
+				"INSERT INTO products_fts(products_fts, rowid, name) VALUES ('delete', old.rowid, old.name); END",
+			"CREATE TRIGGER IF NOT EXISTS products_fts_au AFTER UPDATE ON products BEGIN " +
+				"INSERT INTO products_fts(products_fts, rowid, name) VALUES ('delete', old.rowid, old.name); " +
+				"INSERT INTO products_fts(rowid, name) VALUES (new.rowid, new.name); END",
+			"INSERT INTO products_fts(products_fts) VALUES ('rebuild')",
+			"SELECT `products`.`id` AS id, -(SELECT bm25(products_fts) FROM products_fts WHERE products_fts MATCH '\"laptop\" OR \"\"\"gaming\"\"\"' AND products_fts.rowid = products.rowid) AS score " +
+				"FROM `products` WHERE products.rowid IN (SELECT rowid FROM products_fts WHERE products_fts MATCH '\"laptop\" OR \"\"\"gaming\"\"\"') ORDER BY score desc LIMIT 5",
+		},
+	}
+	for name, statements := range expected {
+		recorder := &sqlRecorder{Interface: logger.Discard}
+		dryRun, err := gorm.Open(fullTextDialector{
+			Dialector: mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}),
+			name:      name,
+		}, &gorm.Config{DryRun: true, Logger: recorder})
+		require.Nil(t, err)
+
+		err = CreateFullTextIndex(dryRun, ProductFullTextIndex)
+		assert.Nil(t, err, name)
+		// the ranked ids can not be scanned in DryRun, the statement is still built
+		_, err = NewProductSearch(dryRun).Search(`laptop "gaming"`, 5)
+		assert.ErrorIs(t, err, gorm.ErrDryRunModeUnsupported, name)
+		assert.Equal(t, statements, recorder.statements, name)
+	}
+}
+
+func TestFullTextSearchTodoAndGuestBook(t *testing.T) {
+	todo := Todo{UserId: "9", Title: "Belajar fulltext", Description: "index fulltext mysql"}
+	err := db.Create(&todo).Error
+	assert.Nil(t, err)
+	deleted := Todo{UserId: "9", Title: "fulltext dihapus"}
+	err = db.Create(&deleted).Error
+	assert.Nil(t, err)
+	err = db.Delete(&deleted).Error
+	assert.Nil(t, err)
+
+	// soft deleted todos are not found
+	todos, err := NewTodoSearch(db).Search("fulltext", 10)
+	require.Nil(t, err)
+	require.Len(t, todos, 1)
+	assert.Equal(t, todo.ID, todos[0].Item.ID)
+
+	guestBookService := NewGuestBookService(db, nil)
+	approved, err := guestBookService.Post("Habibi", "fulltext@example.com", "Website fulltext keren")
+	assert.Nil(t, err)
+	err = guestBookService.Approve(approved.ID)
+	assert.Nil(t, err)
+	_, err = guestBookService.Post("Habibi", "fulltext@example.com", "fulltext belum dimoderasi")
+	assert.Nil(t, err)
+
+	messages, err := NewGuestBookSearch(db).Search("fulltext", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(messages))
+
+	messages, err = NewGuestBookSearch(db.Where("status = ?", GuestBookStatusApproved)).Search("fulltext", 10)
+	require.Nil(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, approved.ID, messages[0].Item.ID)
+}
+
+func TestFtsQuery(t *testing.T) {
+	assert.Equal(t, `"laptop" OR "gaming"`, ftsQuery(" laptop  gaming "))
+	assert.Equal(t, `"NEAR(a" OR "b)" OR "say" OR """hi"""`, ftsQuery(`NEAR(a b) say "hi"`))
+}
+
+func TestStream(t *testing.T) {
+	var count int64
+	err := db.Model(&User{}).Count(&count).Error
+	assert.Nil(t, err)
+
+	it := Stream[User](allTenants, db.Order("id asc"))
+	var users []User
+	for it.Next() {
+		users = append(users, it.Value())
+	}
+	assert.Nil(t, it.Err())
+	assert.Equal(t, int(count), len(users))
+	assert.NotEqual(t, "", users[0].Name.FirstName)
+
+	// the rows are closed so the connection is back in the pool
+	sqlDB, err := db.DB()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, sqlDB.Stats().InUse)
+}
+
+func TestStreamCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(allTenants)
+	it := Stream[User](ctx, db)
+	assert.True(t, it.Next())
+	cancel()
+	assert.False(t, it.Next())
+	assert.ErrorIs(t, it.Err(), context.Canceled)
+	assert.Nil(t, it.Close())
+
+	sqlDB, err := db.DB()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, sqlDB.Stats().InUse)
+}
+
+func TestStreamEach(t *testing.T) {
+	stop := errors.New("stop")
+	visited := 0
+	err := StreamEach(allTenants, db.Where("id <> ?", ""), func(user User) error {
+		visited++
+		if visited == 3 {
+			return stop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 3, visited)
+
+	sqlDB, err := db.DB()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, sqlDB.Stats().InUse)
+
+	// closing the iterator before the last row releases the connection as well
+	it := Stream[User](allTenants, db)
+	assert.True(t, it.Next())
+	assert.Nil(t, it.Close())
+	assert.Nil(t, it.Err())
+	assert.Equal(t, 0, sqlDB.Stats().InUse)
+}
+
+func TestStreamBatches(t *testing.T) {
+	var count int64
+	err := db.Model(&User{}).Count(&count).Error
+	assert.Nil(t, err)
+
+	total := 0
+	batches := 0
+	err = StreamBatches(allTenants, db, 5, func(batch []User) error {
+		assert.LessOrEqual(t, len(batch), 5)
+		total += len(batch)
+		batches++
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int(count), total)
+	assert.Equal(t, int(count+4)/5, batches)
+
+	ctx, cancel := context.WithCancel(allTenants)
+	batches = 0
+	err = StreamBatches(ctx, db, 2, func(batch []User) error {
+		batches++
+		cancel()
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, batches)
+}
+
+func TestImportUsersCSV(t *testing.T) {
+	input := `id,password,first_name,middle_name,last_name
+import-1,rahasia,Habibi,,Iberahim
+import-2,rahasia,,,Tanpa Nama
+import-3,123,Password Pendek,,
+import-4,rahasia,Kolom Kurang
+1,rahasia,Sudah Ada,,
+import-5,rahasia,Budi,,`
+
+	report, err := NewUserImporter(db).Import(strings.NewReader(input), ImportOptions{
+		Format:    ImportCSV,
+		ChunkSize: 2,
+		Conflict:  ConflictSkip,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 6, report.Total)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 3, report.Failed)
+	require.Len(t, report.Errors, 3)
+	assert.Equal(t, 3, report.Errors[0].Line)
+	assert.Equal(t, "is required", report.Errors[0].Fields["name.first_name"])
+	assert.Equal(t, 4, report.Errors[1].Line)
+	assert.Equal(t, "must be at least 6 characters", report.Errors[1].Fields["password"])
+	assert.Equal(t, 5, report.Errors[2].Line)
+
+	var imported User
+	err = db.Take(&imported, "id = ?", "import-1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, "Iberahim", imported.Name.LastName)
+
+	var existing User
+	err = db.Take(&existing, "id = ?", "1").Error
+	assert.Nil(t, err)
+	assert.NotEqual(t, "Sudah Ada", existing.Name.FirstName)
+
+	_, err = NewUserImporter(db).Import(strings.NewReader("id,nama\n1,Habibi"), ImportOptions{Format: ImportCSV})
+	assert.ErrorIs(t, err, ErrInvalidImportHeader)
+}
+
+func TestImportProductsJSONL(t *testing.T) {
+	err := db.Create(&Product{ID: "import-1", Name: "Produk Lama", Price: 1000, CreatedAt: time.Now().Add(-time.Hour)}).Error
+	assert.Nil(t, err)
+
+	input := `{"id":"import-1","name":"Produk Baru","price":5000}
+{"id":"import-2","name":"Produk Import","price":2000}
+
+{"id":"import-3","name":"Harga Negatif","price":-1}
+{"id":"import-4","nama":"Kolom Salah","price":1}
+{"id":"import-5",`
+	report, err := NewProductImporter(db).Import(strings.NewReader(input), ImportOptions{
+		Format:   ImportJSONL,
+		Conflict: ConflictUpdateColumns,
+		Columns:  []string{"name"},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 5, report.Total)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 3, report.Failed)
+	require.Len(t, report.Errors, 3)
+	assert.Equal(t, []int{4, 5, 6}, []int{report.Errors[0].Line, report.Errors[1].Line, report.Errors[2].Line})
+
+	var product Product
+	err = db.Take(&product, "id = ?", "import-1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, "Produk Baru", product.Name)
+	assert.Equal(t, int64(1000), product.Price)
+
+	err = NewLikeService(db).Like("1", "import-1")
+	assert.Nil(t, err)
+
+	report, err = NewProductImporter(db).Import(strings.NewReader(`{"id":"import-1","name":"Produk Baru","price":7000}`), ImportOptions{
+		Format:   ImportJSONL,
+		Conflict: ConflictUpdateAll,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Imported)
+
+	// only the imported columns are overwritten
+	var updated Product
+	err = db.Take(&updated, "id = ?", "import-1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7000), updated.Price)
+	assert.Equal(t, int64(1), updated.LikeCount)
+	assert.Equal(t, product.CreatedAt, updated.CreatedAt)
+
+	// the overwritten price is kept in the price history
+	history, err := NewPriceService(db).History("import-1")
+	assert.Nil(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, int64(1000), history[0].Price)
+	assert.NotNil(t, history[0].EffectiveTo)
+	assert.Equal(t, int64(7000), history[1].Price)
+	assert.Nil(t, history[1].EffectiveTo)
+
+	// columns can be given by field name and only documented fields are read
+	report, err = NewProductImporter(db).Import(strings.NewReader(`{"id":"import-1","name":"Produk Nama Field","price":7000}
+{"id":"import-2","name":"Produk Import","price":2000,"like_count":100}`), ImportOptions{
+		Format:   ImportJSONL,
+		Conflict: ConflictUpdateColumns,
+		Columns:  []string{"Name"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, 1, report.Failed)
+	err = db.Take(&updated, "id = ?", "import-1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, "Produk Nama Field", updated.Name)
+
+	_, err = NewProductImporter(db).Import(strings.NewReader(input), ImportOptions{
+		Format:   ImportJSONL,
+		Conflict: ConflictUpdateColumns,
+		Columns:  []string{"id"},
+	})
+	assert.ErrorIs(t, err, ErrInvalidConflictColumns)
+
+	_, err = NewProductImporter(db).Import(strings.NewReader(input), ImportOptions{
+		Format:   ImportJSONL,
+		Conflict: ConflictUpdateColumns,
+		Columns:  []string{"like_count"},
+	})
+	assert.ErrorIs(t, err, ErrInvalidConflictColumns)
+}
+
+func TestImportRejectedRows(t *testing.T) {
+	rejecting, err := OpenDatabase(DefaultDSN)
+	assert.Nil(t, err)
+	rejecting = rejecting.WithContext(allTenants)
+	err = rejecting.Callback().Create().Before("gorm:create").Register("test:reject", func(tx *gorm.DB) {
+		reject := func(product Product) {
+			if product.ID == "import-reject" {
+				tx.AddError(errors.New("rejected by database"))
+			}
+		}
+		switch dest := tx.Statement.Dest.(type) {
+		case *Product:
+			reject(*dest)
+		case *[]Product:
+			for _, product := range *dest {
+				reject(product)
+			}
+		}
+	})
+	assert.Nil(t, err)
+
+	input := "id,name,price\nimport-6,Produk,1000\nimport-reject,Produk,1000\nimport-7,Produk,1000\n"
+	report, err := NewProductImporter(rejecting).Import(strings.NewReader(input), ImportOptions{Format: ImportCSV})
+	require.Nil(t, err)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 1, report.Failed)
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 3, report.Errors[0].Line)
+	assert.Equal(t, "rejected by database", report.Errors[0].Message)
+}
+
+func TestExportCSV(t *testing.T) {
+	for i := 1; i <= 3; i++ {
+		err := db.Create(&User{
+			ID:       "export-" + strconv.Itoa(i),
+			Password: "rahasia",
+			Name:     Name{FirstName: "Export " + strconv.Itoa(i)},
+		}).Error
+		assert.Nil(t, err)
+	}
+
+	var buffer bytes.Buffer
+	query := db.Where("id LIKE ?", "export-%").Order("id asc")
+	count, err := Export[User](allTenants, query, &buffer, ExportOptions{
+		Format:  ExportCSV,
+		Columns: []string{"id", "password", "first_name", "middle_name"},
+		Redact:  []string{"middle_name"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+	assert.Equal(t, "id,password,first_name,middle_name\n"+
+		"export-1,[REDACTED],Export 1,[REDACTED]\n"+
+		"export-2,[REDACTED],Export 2,[REDACTED]\n"+
+		"export-3,[REDACTED],Export 3,[REDACTED]\n", buffer.String())
+
+	_, err = Export[User](allTenants, query, &buffer, ExportOptions{Format: ExportCSV, Columns: []string{"wallet"}})
+	assert.ErrorIs(t, err, ErrUnknownExportColumn)
+	_, err = Export[User](allTenants, query, &buffer, ExportOptions{Format: "xml"})
+	assert.ErrorIs(t, err, ErrUnknownExportFormat)
+}
+
+func TestExportJSONL(t *testing.T) {
+	err := db.Create(&Product{ID: "export-1", Name: "Produk Export", Price: 150000}).Error
+	assert.Nil(t, err)
+
+	var buffer bytes.Buffer
+	query := db.Where("id = ?", "export-1")
+	count, err := Export[Product](allTenants, query, &buffer, ExportOptions{Format: ExportJSONL})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.True(t, strings.HasPrefix(buffer.String(), `{"id":"export-1","name":"Produk Export","price":150000,"like_count":0,"created_at":`))
+
+	var product map[string]interface{}
+	err = json.Unmarshal(buffer.Bytes(), &product)
+	assert.Nil(t, err)
+	assert.Len(t, product, 7)
+}
+
+func TestExportParquet(t *testing.T) {
+	var buffer bytes.Buffer
+	query := db.Where("id LIKE ?", "export-%").Order("id asc")
+	count, err := Export[User](allTenants, query, &buffer, ExportOptions{
+		Format:       ExportParquet,
+		Columns:      []string{"id", "password", "first_name", "middle_name", "created_at"},
+		RowGroupSize: 2,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+
+	file, err := parquet.OpenFile(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), file.NumRows())
+	assert.Len(t, file.RowGroups(), 2)
+
+	// rows are read one row group at a time and their values reuse the buffers of the reader
+	var rows []parquet.Row
+	reader := parquet.NewReader(file)
+	for {
+		batch := make([]parquet.Row, 3)
+		n, err := reader.ReadRows(batch)
+		for _, row := range batch[:n] {
+			rows = append(rows, row.Clone())
+		}
+		if err != nil {
+			break
+		}
+	}
+	require.Len(t, rows, 3)
+	column := func(name string) int {
+		leaf, _ := file.Schema().Lookup(name)
+		return leaf.ColumnIndex
+	}
+	assert.Equal(t, "export-1", rows[0][column("id")].String())
+	assert.Equal(t, "[REDACTED]", rows[0][column("password")].String())
+	assert.Equal(t, "Export 3", rows[2][column("first_name")].String())
+	assert.False(t, rows[0][column("created_at")].IsNull())
+}
+
+func openWithSlogLogger(t *testing.T, buffer *bytes.Buffer, config SlogLoggerConfig) *gorm.DB {
+	sqlLogger := NewSlogLogger(slog.New(slog.NewJSONHandler(buffer, nil)), config)
+	// bind variables are interpolated by the driver so EXPLAIN is not sent as a prepared statement
+	logged, err := gorm.Open(mysql.Open(DefaultDSN+"&interpolateParams=true"), &gorm.Config{Logger: sqlLogger, SkipDefaultTransaction: true})
+	assert.Nil(t, err)
+	err = logged.Use(sqlLogger)
+	assert.Nil(t, err)
+	return logged
+}
+
+func decodeLogRecords(t *testing.T, buffer *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	decoder := json.NewDecoder(buffer)
+	for decoder.More() {
+		var record map[string]interface{}
+		err := decoder.Decode(&record)
+		assert.Nil(t, err)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logged := openWithSlogLogger(t, &buffer, SlogLoggerConfig{LogLevel: logger.Info})
+
+	err := logged.Create(&User{ID: "slog-1", Password: "rahasia", Name: Name{FirstName: "Rahasia Nama"}}).Error
+	assert.Nil(t, err)
+
+	records := decodeLogRecords(t, &buffer)
+	require.Len(t, records, 1)
+	assert.Equal(t, "INFO", records[0]["level"])
+	assert.Equal(t, "query", records[0]["msg"])
+	assert.Contains(t, records[0]["sql"], "INSERT INTO `users`")
+	assert.Contains(t, records[0]["sql"], "?")
+	assert.NotContains(t, records[0]["sql"], "Rahasia Nama")
+	assert.Equal(t, float64(1), records[0]["rows"])
+	assert.Contains(t, records[0]["caller"], "gorm_test.go:")
+	assert.Contains(t, records[0], "duration")
+
+	valued := openWithSlogLogger(t, &buffer, SlogLoggerConfig{LogLevel: logger.Info, LogValues: true})
+	err = valued.Where("id = ?", "slog-1").Updates(&User{Password: "rahasia2"}).Error
+	assert.Nil(t, err)
+
+	records = decodeLogRecords(t, &buffer)
+	require.Len(t, records, 1)
+	assert.Contains(t, records[0]["sql"], "'slog-1'")
+	assert.Contains(t, records[0]["sql"], "[REDACTED]")
+	assert.NotContains(t, records[0]["sql"], "rahasia2")
+}
+
+func TestSlogLoggerSlowQuery(t *testing.T) {
+	var buffer bytes.Buffer
+	logged := openWithSlogLogger(t, &buffer, SlogLoggerConfig{LogLevel: logger.Warn, SlowThreshold: time.Nanosecond})
+
+	var users []User
+	err := logged.Where("id = ?", "1").Find(&users).Error
+	assert.Nil(t, err)
+
+	records := decodeLogRecords(t, &buffer)
+	require.Len(t, records, 1)
+	assert.Equal(t, "WARN", records[0]["level"])
+	assert.Equal(t, "slow query", records[0]["msg"])
+	assert.Equal(t, float64(time.Nanosecond), records[0]["threshold"])
+	assert.NotEmpty(t, records[0]["explain"])
+	assert.NotContains(t, records[0], "explain_error")
+}
+
+func TestSlogLoggerExplainNotPrepared(t *testing.T) {
+	opened, err := OpenDatabase(DefaultDSN)
+	assert.Nil(t, err)
+	opened = opened.WithContext(allTenants)
+	var buffer bytes.Buffer
+	sqlLogger := NewSlogLogger(slog.New(slog.NewJSONHandler(&buffer, nil)), SlogLoggerConfig{LogLevel: logger.Warn, SlowThreshold: time.Nanosecond})
+	sqlLogger.db = opened
+
+	var users []User
+	err = opened.Session(&gorm.Session{Logger: sqlLogger}).Where("id = '1'").Find(&users).Error
+	assert.Nil(t, err)
+	records := decodeLogRecords(t, &buffer)
+	require.Len(t, records, 1)
+	assert.Equal(t, "slow query", records[0]["msg"])
+
+	prepared, ok := opened.ConnPool.(*gorm.PreparedStmtDB)
+	require.True(t, ok)
+	prepared.Mux.RLock()
+	defer prepared.Mux.RUnlock()
+	assert.NotEmpty(t, prepared.Stmts)
+	for query := range prepared.Stmts {
+		assert.NotContains(t, query, "EXPLAIN")
+	}
+}
+
+func TestSlogLoggerError(t *testing.T) {
+	var buffer bytes.Buffer
+	logged := openWithSlogLogger(t, &buffer, SlogLoggerConfig{LogLevel: logger.Error})
+
+	var user User
+	err := logged.Take(&user, "id = ?", "tidak-ada").Error
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	err = logged.Find(&[]User{}).Error
+	assert.Nil(t, err)
+
+	records := decodeLogRecords(t, &buffer)
+	require.Len(t, records, 1)
+	assert.Equal(t, "ERROR", records[0]["level"])
+	assert.Equal(t, "record not found", records[0]["error"])
+
+	ignoring := openWithSlogLogger(t, &buffer, SlogLoggerConfig{LogLevel: logger.Error, IgnoreRecordNotFoundError: true})
+	err = ignoring.Take(&User{}, "id = ?", "tidak-ada").Error
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.Empty(t, decodeLogRecords(t, &buffer))
+}
+
+func TestSanitizeSQL(t *testing.T) {
+	assert.Equal(t, "SELECT * FROM users WHERE id = ? AND first_name = ? LIMIT ?",
+		SanitizeSQL("SELECT * FROM users\n\tWHERE id = 'it''s' AND first_name = 'Eko\\'s' LIMIT 10"))
+	assert.Equal(t, "SELECT * FROM `wallets` WHERE user_id = ?", SanitizeSQL("SELECT * FROM `wallets` WHERE user_id = ?"))
+}
+
+func TestTracingPlugin(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	reader := sdkmetric.NewManualReader()
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	plugin, err := NewTracingPlugin(tracerProvider, meterProvider)
+	assert.Nil(t, err)
+	traced, err := gorm.Open(mysql.Open(DefaultDSN), &gorm.Config{Logger: logger.Discard, SkipDefaultTransaction: true})
+	assert.Nil(t, err)
+	err = traced.Use(plugin)
+	assert.Nil(t, err)
+
+	ctx, parent := tracerProvider.Tracer("test").Start(context.Background(), "request")
+	var users []User
+	err = traced.WithContext(ctx).Where("id = ?", "1").Find(&users).Error
+	assert.Nil(t, err)
+	err = traced.WithContext(ctx).Exec("SELECT * FROM tidak_ada WHERE id = 'rahasia'").Error
+	assert.NotNil(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+	query := spans[0]
+	assert.Equal(t, "gorm.select", query.Name)
+	assert.Equal(t, parent.SpanContext().SpanID(), query.Parent.SpanID())
+	attrs := attribute.NewSet(query.Attributes...)
+	table, _ := attrs.Value("db.collection.name")
+	assert.Equal(t, "users", table.AsString())
+	statement, _ := attrs.Value("db.query.text")
+	assert.Equal(t, "SELECT * FROM `users` WHERE id = ?", statement.AsString())
+	assert.Equal(t, codes.Unset, query.Status.Code)
+
+	raw := spans[1]
+	assert.Equal(t, "gorm.raw", raw.Name)
+	assert.Equal(t, codes.Error, raw.Status.Code)
+	rawAttrs := attribute.NewSet(raw.Attributes...)
+	statement, _ = rawAttrs.Value("db.query.text")
+	assert.Equal(t, "SELECT * FROM tidak_ada WHERE id = ?", statement.AsString())
+
+	var metrics metricdata.ResourceMetrics
+	err = reader.Collect(context.Background(), &metrics)
+	assert.Nil(t, err)
+	recorded := map[string]metricdata.Aggregation{}
+	for _, scope := range metrics.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			recorded[m.Name] = m.Data
+		}
+	}
+	duration := recorded["db.client.operation.duration"].(metricdata.Histogram[float64])
+	assert.Len(t, duration.DataPoints, 2)
+	errorCount := recorded["db.client.operation.errors"].(metricdata.Sum[int64])
+	require.Len(t, errorCount.DataPoints, 1)
+	assert.Equal(t, int64(1), errorCount.DataPoints[0].Value)
+	operation, _ := errorCount.DataPoints[0].Attributes.Value("db.operation.name")
+	assert.Equal(t, "raw", operation.AsString())
+}
+
+func TestTracingPluginNestedSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	plugin, err := NewTracingPlugin(tracerProvider, sdkmetric.NewMeterProvider())
+	assert.Nil(t, err)
+	traced, err := gorm.Open(mysql.Open(DefaultDSN), &gorm.Config{Logger: logger.Discard, SkipDefaultTransaction: true})
+	assert.Nil(t, err)
+	err = traced.Use(plugin)
+	assert.Nil(t, err)
+
+	err = traced.Create(&User{ID: "tracing-1", Password: "rahasia", Name: Name{FirstName: "Tracing"}}).Error
+	assert.Nil(t, err)
+	exporter.Reset()
+
+	ctx, parent := tracerProvider.Tracer("test").Start(context.Background(), "request")
+	var users []User
+	tx := traced.WithContext(ctx).Preload("Wallet").Where("id = ?", "tracing-1").Find(&users)
+	assert.Nil(t, tx.Error)
+	assert.Equal(t, ctx, tx.Statement.Context)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+	preload, query := spans[0], spans[1]
+	assert.Equal(t, "gorm.select", query.Name)
+	assert.Equal(t, parent.SpanContext().SpanID(), query.Parent.SpanID())
+	assert.Equal(t, "gorm.select", preload.Name)
+	assert.Equal(t, query.SpanContext.SpanID(), preload.Parent.SpanID())
+}
+
+func TestMetricsCollector(t *testing.T) {
+	collector := NewMetricsCollector()
+	measured, err := gorm.Open(mysql.Open(DefaultDSN), &gorm.Config{Logger: logger.Discard, SkipDefaultTransaction: true})
+	assert.Nil(t, err)
+	err = measured.Use(collector)
+	assert.Nil(t, err)
+
+	var wallets []Wallet
+	err = measured.Find(&wallets).Error
+	assert.Nil(t, err)
+	err = measured.Exec("SELECT * FROM tidak_ada").Error
+	assert.NotNil(t, err)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.queries.WithLabelValues("wallets", "select", "ok")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.queries.WithLabelValues("", "raw", "error")))
+
+	expected := `
+# HELP gorm_db_max_open_connections Maximum number of open connections to the database.
+# TYPE gorm_db_max_open_connections gauge
+gorm_db_max_open_connections 0
+`
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected), "gorm_db_max_open_connections")
+	assert.Nil(t, err)
+}
+
+type healthMissingTable struct {
+	ID string `gorm:"column:id"`
+}
+
+func (h *healthMissingTable) TableName() string {
+	return "tidak_ada"
+}
+
+type healthPendingColumn struct {
+	ID       string `gorm:"column:id"`
+	Nickname string `gorm:"column:nickname"`
+}
+
+func (h *healthPendingColumn) TableName() string {
+	return "users"
+}
+
+func TestHealth(t *testing.T) {
+	report := NewHealthChecker(db, HealthOptions{}).Health(context.Background())
+	assert.Equal(t, HealthOK, report.Status)
+	require.Len(t, report.Checks, 3)
+	for _, check := range report.Checks {
+		assert.Equal(t, HealthOK, check.Status, check.Name)
+	}
+
+	report = NewHealthChecker(db, HealthOptions{}).Live(context.Background())
+	assert.Equal(t, HealthOK, report.Status)
+	require.Len(t, report.Checks, 1)
+	assert.Equal(t, "ping", report.Checks[0].Name)
+}
+
+func TestHealthWithPlugins(t *testing.T) {
+	pluginDB, err := OpenDatabase(DefaultDSN)
+	assert.Nil(t, err)
+	err = pluginDB.Use(NewPolicyPlugin())
+	assert.Nil(t, err)
+
+	report := NewHealthChecker(pluginDB, HealthOptions{Replicas: map[string]*gorm.DB{"primary": pluginDB}}).Health(context.Background())
+	for _, check := range report.Checks {
+		if check.Name == "replica:primary" {
+			// the test database is not a replica, the query itself must not be rejected
+			assert.Equal(t, ErrReplicationNotRunning.Error(), check.Error)
+			continue
+		}
+		assert.Equal(t, HealthOK, check.Status, check.Name+": "+check.Error)
+	}
+}
+
+func TestHealthFailing(t *testing.T) {
+	checker := NewHealthChecker(db, HealthOptions{
+		Models:   []interface{}{&User{}, &healthMissingTable{}, &healthPendingColumn{}},
+		Replicas: map[string]*gorm.DB{"primary": db},
+	})
+	report := checker.Health(context.Background())
+	assert.Equal(t, HealthFail, report.Status)
+	assert.Len(t, report.Checks, 4)
+
+	checks := map[string]HealthCheck{}
+	for _, check := range report.Checks {
+		checks[check.Name] = check
+	}
+	assert.Equal(t, HealthOK, checks["ping"].Status)
+	assert.Equal(t, "missing tables tidak_ada", checks["tables"].Error)
+	assert.Equal(t, "pending migrations, missing columns users.nickname", checks["migrations"].Error)
+	assert.Equal(t, ErrReplicationNotRunning.Error(), checks["replica:primary"].Error)
+
+	ctx, cancel := context.WithCancel(allTenants)
+	cancel()
+	report = checker.Live(ctx)
+	assert.Equal(t, HealthFail, report.Status)
+}
+
+// recordingPool records the statements sent outside of a transaction
+type recordingPool struct {
+	*sql.DB
+	mutex      sync.Mutex
+	statements []string
+}
+
+func (p *recordingPool) record(query string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.statements = append(p.statements, query)
+}
+
+func (p *recordingPool) take() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	statements := p.statements
+	p.statements = nil
+	return statements
+}
+
+func (p *recordingPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	p.record(query)
+	return p.DB.ExecContext(ctx, query, args...)
+}
+
+func (p *recordingPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	p.record(query)
+	return p.DB.QueryContext(ctx, query, args...)
+}
+
+func (p *recordingPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	p.record(query)
+	return p.DB.QueryRowContext(ctx, query, args...)
+}
+
+func openRecording(t *testing.T) (*gorm.DB, *recordingPool) {
+	sqlDB, err := sql.Open("mysql", DefaultDSN)
+	assert.Nil(t, err)
+	pool := &recordingPool{DB: sqlDB}
+	recorded, err := gorm.Open(mysql.New(mysql.Config{Conn: pool, SkipInitializeWithVersion: true}),
+		&gorm.Config{Logger: logger.Discard, SkipDefaultTransaction: true})
+	assert.Nil(t, err)
+	return recorded, pool
+}
+
+func TestReplicaResolver(t *testing.T) {
+	replica, replicaPool := openRecording(t)
+	primary, primaryPool := openRecording(t)
+	err := primary.Use(NewReplicaResolver(replica))
+	assert.Nil(t, err)
+	assert.Contains(t, ReplicasOf(primary), "replica-1")
+	assert.Nil(t, ReplicasOf(db))
+
+	err = primary.Create(&User{ID: "replica-1", Password: "rahasia", Name: Name{FirstName: "Replica"}}).Error
+	assert.Nil(t, err)
+	assert.Len(t, primaryPool.take(), 1)
+
+	var users []User
+	err = primary.Find(&users, "id = ?", "replica-1").Error
+	assert.Nil(t, err)
+	assert.Len(t, replicaPool.take(), 1)
+	assert.Empty(t, primaryPool.take())
+
+	var user User
+	err = primary.Preload("Wallet").Take(&user, "id = ?", "replica-1").Error
+	assert.Nil(t, err)
+	assert.Len(t, replicaPool.take(), 2)
+	assert.Empty(t, primaryPool.take())
+
+	var count int64
+	err = primary.Raw("SELECT COUNT(*) FROM users").Scan(&count).Error
+	assert.Nil(t, err)
+	assert.Len(t, replicaPool.take(), 1)
+
+	err = primary.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&User{}, "id = ?", "replica-1").Error
+	assert.Nil(t, err)
+	statements := primaryPool.take()
+	require.Len(t, statements, 1)
+	assert.Contains(t, statements[0], "FOR UPDATE")
+
+	err = primary.WithContext(ForcePrimary(allTenants)).Preload("Wallet").Take(&User{}, "id = ?", "replica-1").Error
+	assert.Nil(t, err)
+	assert.Len(t, primaryPool.take(), 2)
+	assert.Empty(t, replicaPool.take())
+
+	err = primary.Transaction(func(tx *gorm.DB) error {
+		return tx.Take(&User{}, "id = ?", "replica-1").Error
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, replicaPool.take())
+
+	err = primary.Create(&Product{ID: "replica-1", Name: "Produk Replica", Price: 1000}).Error
+	assert.Nil(t, err)
+	statements = primaryPool.take()
+	require.Len(t, statements, 1)
+	assert.Contains(t, statements[0], "INSERT INTO `products`")
+
+	query := primary.Model(&Product{}).Where("id = ?", "replica-1")
+	var product Product
+	err = query.Take(&product).Error
+	assert.Nil(t, err)
+	err = query.Update("name", "Produk Replica Baru").Error
+	assert.Nil(t, err)
+	assert.Len(t, replicaPool.take(), 1)
+	statements = primaryPool.take()
+	require.Len(t, statements, 1)
+	assert.Contains(t, statements[0], "UPDATE `products`")
+
+	err = primary.Exec("UPDATE products SET price = ? WHERE id = ?", 2000, "replica-1").Error
+	assert.Nil(t, err)
+	assert.Len(t, primaryPool.take(), 1)
+	assert.Empty(t, replicaPool.take())
+}
+
+func openShards(t *testing.T) *ShardedDB {
+	err := db.Exec("CREATE DATABASE IF NOT EXISTS belajar_golang_gorm_shard").Error
+	assert.Nil(t, err)
+	for _, table := range []string{"users", "user_logs", "wallets", "addresses", "todos"} {
+		err = db.Exec("CREATE TABLE IF NOT EXISTS belajar_golang_gorm_shard." + table + " LIKE " + table).Error
+		assert.Nil(t, err)
+	}
+
+	first, err := OpenDatabase(DefaultDSN)
+	assert.Nil(t, err)
+	second, err := OpenDatabase(strings.Replace(DefaultDSN, "/belajar_golang_gorm?", "/belajar_golang_gorm_shard?", 1))
+	assert.Nil(t, err)
+	sharded, err := NewShardedDB(first.WithContext(allTenants), second.WithContext(allTenants))
+	assert.Nil(t, err)
+	return sharded
+}
+
+// shardUserIds returns ids of users on the first and on the second of two shards
+func shardUserIds(count int) ([]string, []string) {
+	var first, second []string
+	for i := 1; len(first) < count || len(second) < count; i++ {
+		id := "shard-" + strconv.Itoa(i)
+		if ShardIndex(id, 2) == 0 && len(first) < count {
+			first = append(first, id)
+		} else if ShardIndex(id, 2) == 1 && len(second) < count {
+			second = append(second, id)
+		}
+	}
+	return first, second
+}
+
+func TestShardedDB(t *testing.T) {
+	sharded := openShards(t)
+	first, second := shardUserIds(3)
+	for _, id := range append(append([]string{}, first...), second...) {
+		err := sharded.For(id).Create(&User{ID: id, Password: "rahasia", Name: Name{FirstName: "Shard " + id}}).Error
+		assert.Nil(t, err)
+		err = sharded.For(id).Create(&Wallet{ID: "wallet-" + id, UserId: id, Balance: 1000}).Error
+		assert.Nil(t, err)
+	}
+
+	var user User
+	err := sharded.For(second[0]).Preload("Wallet").Take(&user, "id = ?", second[0]).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), user.Wallet.Balance)
+	err = sharded.Shards()[0].Take(&User{}, "id = ?", second[0]).Error
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	err = sharded.Shards()[0].Create(&Todo{UserId: second[0], Title: "Salah Shard"}).Error
+	assert.ErrorIs(t, err, ErrWrongShard)
+	err = sharded.Shards()[1].Create(&[]UserLog{{UserId: second[1], Action: "Benar"}, {UserId: first[1], Action: "Salah"}}).Error
+	assert.ErrorIs(t, err, ErrWrongShard)
+}
+
+func TestShardedTransaction(t *testing.T) {
+	sharded := openShards(t)
+	first, second := shardUserIds(2)
+
+	err := sharded.Transaction([]string{first[0], first[1]}, func(tx *gorm.DB) error {
+		err := tx.Model(&Wallet{}).Where("id = ?", "wallet-"+first[0]).Update("balance", gorm.Expr("balance - ?", 100)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Wallet{}).Where("id = ?", "wallet-"+first[1]).Update("balance", gorm.Expr("balance + ?", 100)).Error
+	})
+	assert.Nil(t, err)
+	var wallet Wallet
+	err = sharded.For(first[1]).Take(&wallet, "id = ?", "wallet-"+first[1]).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1100), wallet.Balance)
+
+	err = sharded.Transaction([]string{first[0], second[0]}, func(tx *gorm.DB) error {
+		t.Fatal("cross shard transaction must not start")
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrCrossShardTransaction)
+
+	err = sharded.Transaction([]string{first[0]}, func(tx *gorm.DB) error {
+		return tx.Create(&Address{UserId: second[0], Address: "Jalan Lain Shard"}).Error
+	})
+	assert.ErrorIs(t, err, ErrWrongShard)
+}
+
+func TestShardedFanOut(t *testing.T) {
+	sharded := openShards(t)
+	first, second := shardUserIds(3)
+	ids := append(append([]string{}, first...), second...)
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	onlyShardUsers := func(db *gorm.DB) *gorm.DB {
+		return db.Where("id LIKE ?", "shard-%")
+	}
+	users, err := FanOut[User](allTenants, sharded, "id desc", 1, 3, onlyShardUsers)
+	assert.Nil(t, err)
+	assert.Len(t, users, 3)
+	for i, user := range users {
+		assert.Equal(t, ids[i+1], user.ID)
+	}
+
+	users, err = FanOut[User](allTenants, sharded, "id desc", 10, 3, onlyShardUsers)
+	assert.Nil(t, err)
+	assert.Empty(t, users)
+
+	total, err := FanOutCount[User](allTenants, sharded, onlyShardUsers)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), total)
+
+	_, err = FanOut[User](allTenants, sharded, "Wallet.balance desc", 0, 3)
+	assert.ErrorIs(t, err, ErrInvalidKeysetOrder)
+}
+
+func TestShardedFanOutMixedCase(t *testing.T) {
+	sharded := openShards(t)
+	titles := []string{"alpha", "Bravo", "charlie", "Delta"}
+	// the titles alternate between the shards so a byte order would put Bravo and Delta first
+	for i, shard := 0, 0; i < len(titles); shard++ {
+		userId := "shard-case-" + strconv.Itoa(shard)
+		if ShardIndex(userId, 2) != i%2 {
+			continue
+		}
+		err := sharded.For(userId).Create(&Todo{UserId: userId, Title: titles[i]}).Error
+		assert.Nil(t, err)
+		i++
+	}
+
+	todos, err := FanOut[Todo](allTenants, sharded, "title asc, id asc", 0, 10, func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id LIKE ?", "shard-case-%")
+	})
+	assert.Nil(t, err)
+	require.Len(t, todos, 4)
+	for i, todo := range todos {
+		assert.Equal(t, titles[i], todo.Title)
+	}
+
+	// encrypted names can not be merged by order
+	_, err = FanOut[User](allTenants, sharded, "first_name asc, id asc", 0, 10)
+	assert.ErrorIs(t, err, ErrEncryptedColumn)
+}
+
+func TestShardGuardWrite(t *testing.T) {
+	sharded := openShards(t)
+	first, second := shardUserIds(1)
+	wrong := sharded.Shards()[0]
+
+	err := wrong.Model(&Wallet{ID: "wallet-" + second[0], UserId: second[0]}).Update("balance", 0).Error
+	assert.ErrorIs(t, err, ErrWrongShard)
+	err = wrong.Where(&Wallet{UserId: second[0]}).Delete(&Wallet{}).Error
+	assert.ErrorIs(t, err, ErrWrongShard)
+	err = wrong.Where(map[string]interface{}{"user_id": []string{first[0], second[0]}}).Delete(&Todo{}).Error
+	assert.ErrorIs(t, err, ErrWrongShard)
+	err = wrong.Save(&Wallet{ID: "wallet-" + second[0], UserId: second[0], Balance: 0}).Error
+	assert.ErrorIs(t, err, ErrWrongShard)
+
+	// a row can not be moved to a user of another shard
+	err = wrong.Model(&Wallet{}).Where("id = ?", "wallet-"+first[0]).Updates(map[string]interface{}{"user_id": second[0]}).Error
+	assert.ErrorIs(t, err, ErrWrongShard)
+	err = wrong.Model(&Wallet{ID: "wallet-" + first[0], UserId: first[0]}).Update("balance", 1000).Error
+	assert.Nil(t, err)
+}
+
+func openTenant(t *testing.T) *gorm.DB {
+	tenantDB, err := OpenDatabase(DefaultDSN)
+	assert.Nil(t, err)
+	return tenantDB
+}
+
+func TestTenantPlugin(t *testing.T) {
+	tenantDB := openTenant(t)
+	acme := tenantDB.WithContext(WithTenant(context.Background(), "acme"))
+	globex := tenantDB.WithContext(WithTenant(context.Background(), "globex"))
+
+	users := []User{
+		{ID: "tenant-acme-1", Password: "rahasia", Name: Name{FirstName: "Acme"}},
+		{ID: "tenant-acme-2", Password: "rahasia", Name: Name{FirstName: "Acme"}},
+	}
+	err := acme.Create(&users).Error
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", users[0].TenantId)
+	assert.Equal(t, "acme", users[1].TenantId)
+
+	other := User{ID: "tenant-globex-1", Password: "rahasia", Name: Name{FirstName: "Globex"}}
+	err = globex.Create(&other).Error
+	assert.Nil(t, err)
+
+	var found []User
+	err = acme.Where("id LIKE ?", "tenant-%").Order("id asc").Find(&found).Error
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(found))
+
+	// an OR in the conditions must not reach the rows of another tenant
+	found = []User{}
+	err = acme.Where("id = ?", "tenant-acme-1").Or("id = ?", "tenant-globex-1").Find(&found).Error
+	require.Nil(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "tenant-acme-1", found[0].ID)
+
+	var count int64
+	err = globex.Model(&User{}).Where("id LIKE ?", "tenant-%").Count(&count).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	result := acme.Model(&User{}).Where("id = ?", "tenant-globex-1").Update("first_name", "Hacked")
+	assert.Nil(t, result.Error)
+	assert.Equal(t, int64(0), result.RowsAffected)
+	result = acme.Delete(&User{ID: "tenant-globex-1"})
+	assert.Nil(t, result.Error)
+	assert.Equal(t, int64(0), result.RowsAffected)
+
+	// the tenant of a row can not be changed by an update
+	err = acme.Model(&User{}).Where("id = ?", "tenant-acme-1").Updates(map[string]interface{}{"tenant_id": "globex"}).Error
+	assert.Nil(t, err)
+
+	all := []User{}
+	err = tenantDB.WithContext(WithoutTenant(context.Background())).Where("id LIKE ?", "tenant-%").Order("id asc").Find(&all).Error
+	require.Nil(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, "acme", all[0].TenantId)
+	assert.Equal(t, "Globex", all[2].Name.FirstName)
+}
+
+func TestTenantPluginFailsClosed(t *testing.T) {
+	tenantDB := openTenant(t)
+
+	var users []User
+	err := tenantDB.Find(&users).Error
+	assert.ErrorIs(t, err, ErrMissingTenant)
+	err = tenantDB.Create(&User{ID: "tenant-missing", Password: "rahasia"}).Error
+	assert.ErrorIs(t, err, ErrMissingTenant)
+	err = tenantDB.Exec("UPDATE users SET first_name = ?", "Nobody").Error
+	assert.ErrorIs(t, err, ErrMissingTenant)
+	err = tenantDB.Table("users").Where("id = ?", "1").Update("first_name", "Nobody").Error
+	assert.ErrorIs(t, err, ErrMissingTenant)
+
+	acme := tenantDB.WithContext(WithTenant(context.Background(), "acme"))
+	err = acme.Model(&User{}).Update("first_name", "Everybody").Error
+	assert.ErrorIs(t, err, gorm.ErrMissingWhereClause)
+	err = acme.Create(&User{ID: "tenant-mismatch", Password: "rahasia", TenantId: "globex"}).Error
+	assert.ErrorIs(t, err, ErrTenantMismatch)
+	err = acme.Clauses(clause.OnConflict{UpdateAll: true}).Create(&User{ID: "tenant-upsert", Password: "rahasia"}).Error
+	assert.ErrorIs(t, err, ErrTenantUpsert)
+}
+
+func TestTenantJoins(t *testing.T) {
+	tenantDB := openTenant(t)
+	acme := tenantDB.WithContext(WithTenant(context.Background(), "acme"))
+	globex := tenantDB.WithContext(WithTenant(context.Background(), "globex"))
+
+	err := acme.Create(&User{ID: "tenant-join-acme", Password: "rahasia", Name: Name{FirstName: "Acme"}}).Error
+	require.Nil(t, err)
+	err = globex.Create(&User{ID: "tenant-join-globex", Password: "rahasia", Name: Name{FirstName: "Globex"}}).Error
+	require.Nil(t, err)
+	// a wallet of another tenant pointing to the user must not be joined
+	err = globex.Create(&Wallet{ID: "tenant-join-wallet", UserId: "tenant-join-acme", Balance: 1000}).Error
+	require.Nil(t, err)
+
+	var user User
+	err = acme.Joins("Wallet").Take(&user, "users.id = ?", "tenant-join-acme").Error
+	require.Nil(t, err)
+	assert.Empty(t, user.Wallet.ID)
+
+	var users []User
+	err = acme.Joins("join wallets on wallets.user_id = users.id").Find(&users).Error
+	assert.ErrorIs(t, err, ErrTenantJoin)
+
+	err = acme.Create(&Product{ID: "tenant-join-product", Name: "Acme Product", Price: 1000}).Error
+	require.Nil(t, err)
+	err = NewLikeService(globex).Like("tenant-join-globex", "tenant-join-product")
+	require.Nil(t, err)
+	err = NewLikeService(acme).Like("tenant-join-acme", "tenant-join-product")
+	require.Nil(t, err)
+
+	products, err := NewLikeService(acme).RecentlyLikedProducts("tenant-join-globex", 1)
+	assert.Nil(t, err)
+	assert.Empty(t, products)
+	products, err = NewLikeService(acme).AlsoLiked("tenant-join-product", 10)
+	assert.Nil(t, err)
+	assert.Empty(t, products)
+	products, err = NewLikeService(acme).MostLiked(10, time.Now().Add(-time.Hour))
+	require.Nil(t, err)
+	require.Len(t, products, 1)
+	assert.Equal(t, "tenant-join-product", products[0].ID)
+	assert.Equal(t, int64(1), products[0].LikeCount)
+}
+
+func TestPolicyPlugin(t *testing.T) {
+	policyDB, err := OpenDatabase(DefaultDSN)
+	assert.Nil(t, err)
+	err = policyDB.Use(NewPolicyPlugin())
+	assert.Nil(t, err)
+	policyDB = policyDB.WithContext(allTenants)
+
+	admin := policyDB.WithContext(WithActor(allTenants, Actor{UserId: "admin", Admin: true}))
+	err = admin.Create(&[]User{
+		{ID: "policy-1", Password: "rahasia", Name: Name{FirstName: "Policy 1"}},
+		{ID: "policy-2", Password: "rahasia", Name: Name{FirstName: "Policy 2"}},
+	}).Error
+	assert.Nil(t, err)
+	err = admin.Create(&[]Wallet{
+		{ID: "policy-wallet-1", UserId: "policy-1", Balance: 1000},
+		{ID: "policy-wallet-2", UserId: "policy-2", Balance: 2000},
+	}).Error
+	assert.Nil(t, err)
+
+	owner := policyDB.WithContext(WithActor(allTenants, Actor{UserId: "policy-1"}))
+	var wallet Wallet
+	err = owner.Take(&wallet, "id = ?", "policy-wallet-2").Error
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	err = owner.Take(&wallet, "id = ?", "policy-wallet-1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), wallet.Balance)
+
+	var wallets []Wallet
+	err = owner.Where("id = ?", "policy-wallet-1").Or("id = ?", "policy-wallet-2").Find(&wallets).Error
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(wallets))
+
+	err = owner.Model(&Wallet{ID: "policy-wallet-2"}).Update("balance", 0).Error
+	assert.ErrorIs(t, err, ErrForbidden)
+	err = owner.Where("user_id = ?", "policy-2").Delete(&Wallet{}).Error
+	assert.ErrorIs(t, err, ErrForbidden)
+	err = owner.Create(&Wallet{ID: "policy-wallet-3", UserId: "policy-2"}).Error
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	err = owner.Model(&wallet).Update("balance", 1500).Error
+	assert.Nil(t, err)
+	wallet.UserId = "policy-2"
+	err = owner.Save(&wallet).Error
+	assert.ErrorIs(t, err, ErrForbidden)
+	wallet.UserId = "policy-1"
+
+	// the assigned values must stay writable by the actor too
+	err = owner.Model(&wallet).Updates(map[string]interface{}{"user_id": "policy-2"}).Error
+	assert.ErrorIs(t, err, ErrForbidden)
+	err = owner.Model(&wallet).Update("user_id", "policy-2").Error
+	assert.ErrorIs(t, err, ErrForbidden)
+	err = owner.Model(&wallet).Updates(&Wallet{UserId: "policy-2"}).Error
+	assert.ErrorIs(t, err, ErrForbidden)
+	err = owner.Model(&wallet).Select("user_id").Updates(&Wallet{}).Error
+	assert.ErrorIs(t, err, ErrForbidden)
+	err = owner.Model(&wallet).Updates(map[string]interface{}{"balance": 1500, "user_id": "policy-1"}).Error
+	assert.Nil(t, err)
+
+	// maps are created like the row they describe
+	err = owner.Model(&Wallet{}).Create(map[string]interface{}{"id": "policy-wallet-3", "user_id": "policy-2"}).Error
+	assert.ErrorIs(t, err, ErrForbidden)
+	err = owner.Table("wallets").Create(map[string]interface{}{"id": "policy-wallet-3", "user_id": "policy-2"}).Error
+	assert.ErrorIs(t, err, ErrForbidden)
+	err = admin.Model(&Wallet{}).Create(map[string]interface{}{"id": "policy-wallet-3", "user_id": "policy-2", "balance": 3000}).Error
+	assert.Nil(t, err)
+
+	err = policyDB.Find(&wallets).Error
+	assert.ErrorIs(t, err, ErrForbidden)
+	var products []Product
+	err = policyDB.Limit(1).Find(&products).Error
+	assert.Nil(t, err)
+
+	wallets = []Wallet{}
+	err = admin.Where("id LIKE ?", "policy-wallet-%").Order("id asc").Find(&wallets).Error
+	require.Nil(t, err)
+	require.Len(t, wallets, 3)
+	assert.Equal(t, int64(1500), wallets[0].Balance)
+	assert.Equal(t, "policy-1", wallets[0].UserId)
+	assert.Equal(t, int64(2000), wallets[1].Balance)
+	assert.Equal(t, "policy-2", wallets[2].UserId)
+}
+
+func TestEncryptedSerializer(t *testing.T) {
+	userId := "encrypted-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	err := db.Create(&User{ID: userId, Password: "rahasia", Name: Name{FirstName: "Encrypted", MiddleName: "Tengah"}}).Error
+	assert.Nil(t, err)
+
+	var name struct {
+		FirstName  string
+		MiddleName string
+	}
+	err = db.Raw("SELECT first_name, middle_name FROM users WHERE id = ?", userId).Scan(&name).Error
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(name.FirstName, "enc:test:"))
+	assert.True(t, strings.HasPrefix(name.MiddleName, "enc:test:"))
+	var user User
+	err = db.Scopes(EncryptedEquals("first_name", "Encrypted")).Take(&user, "id = ?", userId).Error
+	assert.Nil(t, err)
+	assert.Equal(t, "Tengah", user.Name.MiddleName)
+
+	address := Address{UserId: userId, Address: "Jl. Rahasia No. 1"}
+	err = db.Create(&address).Error
+	assert.Nil(t, err)
+
+	var stored string
+	err = db.Raw("SELECT address FROM addresses WHERE id = ?", address.ID).Scan(&stored).Error
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(stored, "enc:test:"))
+	assert.NotContains(t, stored, "Rahasia")
+
+	// the same value is encrypted with a new nonce every time
+	other := Address{UserId: userId, Address: "Jl. Rahasia No. 1"}
+	err = db.Create(&other).Error
+	assert.Nil(t, err)
+	var otherStored string
+	err = db.Raw("SELECT address FROM addresses WHERE id = ?", other.ID).Scan(&otherStored).Error
+	assert.Nil(t, err)
+	assert.NotEqual(t, stored, otherStored)
+
+	var found Address
+	err = db.Take(&found, "id = ?", address.ID).Error
+	assert.Nil(t, err)
+	assert.Equal(t, "Jl. Rahasia No. 1", found.Address)
+
+	// a ciphertext moved to another column does not decrypt
+	_, err = testKeyring.Decrypt("street", stored)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+
+	// values are checked against the size of the column once encrypted
+	long := Address{UserId: userId, Address: strings.Repeat("Jl. Panjang ", 25)}
+	err = db.Create(&long).Error
+	assert.Nil(t, err)
+	var foundLong Address
+	err = db.Take(&foundLong, "id = ?", long.ID).Error
+	assert.Nil(t, err)
+	assert.Equal(t, long.Address, foundLong.Address)
+	err = db.Create(&Address{UserId: userId, Address: strings.Repeat("Jl. Panjang ", 35)}).Error
+	assert.ErrorIs(t, err, ErrCiphertextTooLong)
+
+	// a LIKE or an ORDER BY on ciphertexts would silently find or sort nothing
+	var addresses []Address
+	err = db.Scopes(Search([]string{"address"}, "Rahasia")).Find(&addresses).Error
+	assert.ErrorIs(t, err, ErrEncryptedColumn)
+	err = db.Scopes(Search([]string{"street"}, "Rahasia")).Find(&addresses).Error
+	assert.Nil(t, err)
+	filter, err := FilterSchema{"address": {Column: "address", Type: FilterString, Sortable: true}}.Scope("sort:address")
+	assert.Nil(t, err)
+	err = db.Scopes(filter).Find(&addresses).Error
+	assert.ErrorIs(t, err, ErrEncryptedColumn)
+	filter, err = FilterSchema{"address": {Column: "address", Type: FilterString}}.Scope("address:like:Jl*")
+	assert.Nil(t, err)
+	err = db.Scopes(filter).Find(&addresses).Error
+	assert.ErrorIs(t, err, ErrEncryptedColumn)
+
+	SetKeyring(nil)
+	defer SetKeyring(testKeyring)
+	err = db.Take(&found, "id = ?", address.ID).Error
+	assert.ErrorIs(t, err, ErrNoKeyring)
+}
+
+func TestEncryptedDeterministic(t *testing.T) {
+	email := "encrypted-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "@example.com"
+	err := db.Create(&GuestBook{Name: "Encrypted", Email: email, Message: "Halo"}).Error
+	assert.Nil(t, err)
+
+	var stored []string
+	err = db.Model(&GuestBook{}).Scopes(EncryptedEquals("email", email)).Pluck("email", &stored).Error
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stored))
+
+	// rows encrypted with the old key are still found after a rotation
+	rotated, err := NewKeyring("rotated", map[string][]byte{
+		"test":    bytes.Repeat([]byte("k"), 32),
+		"rotated": bytes.Repeat([]byte("r"), 32),
+	})
+	assert.Nil(t, err)
+	SetKeyring(rotated)
+	defer SetKeyring(testKeyring)
+
+	err = db.Create(&GuestBook{Name: "Encrypted", Email: email, Message: "Halo lagi"}).Error
+	assert.Nil(t, err)
+	var guestBooks []GuestBook
+	err = db.Scopes(EncryptedEquals("email", email)).Order("id asc").Find(&guestBooks).Error
+	require.Nil(t, err)
+	require.Len(t, guestBooks, 2)
+	assert.Equal(t, email, guestBooks[0].Email)
+	assert.Equal(t, email, guestBooks[1].Email)
+
+	var raw []string
+	err = db.Raw("SELECT email FROM guest_book WHERE id IN ? ORDER BY id", []int64{guestBooks[0].ID, guestBooks[1].ID}).Scan(&raw).Error
+	require.Nil(t, err)
+	require.Len(t, raw, 2)
+	assert.True(t, strings.HasPrefix(raw[0], "enc:test:"))
+	assert.True(t, strings.HasPrefix(raw[1], "enc:rotated:"))
+
+	// rows written before the column was encrypted are still found
+	err = db.Exec("INSERT INTO guest_book (name, email, message, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		"Plaintext", email, "Halo lama", GuestBookStatusApproved, time.Now(), time.Now()).Error
+	assert.Nil(t, err)
+	guestBooks = []GuestBook{}
+	err = db.Scopes(EncryptedEquals("email", email)).Order("id asc").Find(&guestBooks).Error
+	require.Nil(t, err)
+	require.Len(t, guestBooks, 3)
+	assert.Equal(t, email, guestBooks[2].Email)
+}
+
+func TestRotateKeyring(t *testing.T) {
+	path := t.TempDir() + "/keyring.json"
+	masterKey := bytes.Repeat([]byte("m"), 32)
+	err := RotateKeyring(path, masterKey, "2026-09")
+	assert.Nil(t, err)
+	first, err := LoadKeyring(path, masterKey)
+	assert.Nil(t, err)
+	ciphertext, err := first.Encrypt("address", "Jl. Lama", false)
+	assert.Nil(t, err)
+
+	err = RotateKeyring(path, masterKey, "2026-10")
+	assert.Nil(t, err)
+	second, err := LoadKeyring(path, masterKey)
+	assert.Nil(t, err)
+	value, err := second.Decrypt("address", ciphertext)
+	assert.Nil(t, err)
+	assert.Equal(t, "Jl. Lama", value)
+	ciphertext, err = second.Encrypt("address", "Jl. Baru", false)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(ciphertext, "enc:2026-10:"))
+
+	_, err = LoadKeyring(path, bytes.Repeat([]byte("x"), 32))
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+	err = RotateKeyring(path, bytes.Repeat([]byte("x"), 32), "2026-11")
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+}
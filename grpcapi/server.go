@@ -0,0 +1,68 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"habibiiberahim/belajar-golang-gorm/pb"
+	"log"
+)
+
+// Register registers the user and wallet services on the grpc server
+func Register(server *grpc.Server, db *gorm.DB) {
+	pb.RegisterUserServiceServer(server, &UserServer{db: db})
+	pb.RegisterWalletServiceServer(server, &WalletServer{db: db})
+}
+
+// TenantMetadataKey holds the tenant of a call, the gateway in front of the
+// server sets it from the authenticated client
+const TenantMetadataKey = "x-tenant-id"
+
+// TenantInterceptor scopes the database statements of a call to the tenant of
+// its metadata, install it with grpc.UnaryInterceptor(grpcapi.TenantInterceptor)
+func TenantInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tenants := md.Get(TenantMetadataKey)
+	if len(tenants) != 1 || tenants[0] == "" {
+		return nil, status.Error(codes.InvalidArgument, TenantMetadataKey+" metadata is required")
+	}
+	return handler(belajar.WithTenant(ctx, tenants[0]), req)
+}
+
+func toStatus(err error) error {
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return status.Error(codes.NotFound, "not found")
+	case errors.Is(err, gorm.ErrDuplicatedKey), errors.As(err, &mysqlErr) && mysqlErr.Number == 1062:
+		return status.Error(codes.AlreadyExists, "already exists")
+	case errors.Is(err, gorm.ErrForeignKeyViolated), errors.As(err, &mysqlErr) && (mysqlErr.Number == 1451 || mysqlErr.Number == 1452):
+		return status.Error(codes.FailedPrecondition, "referenced by or references another resource")
+	case errors.Is(err, belajar.ErrInvalidAmount), errors.Is(err, belajar.ErrSameWallet):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, belajar.ErrInsufficientBalance):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		log.Println("database error:", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func encodePageToken(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func decodePageToken(token string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, "invalid page token")
+	}
+	return string(id), nil
+}
@@ -0,0 +1,194 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"habibiiberahim/belajar-golang-gorm/pb"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// OpenClient serves the grpc server on an in memory listener so no network is needed
+func OpenClient() *grpc.ClientConn {
+	db, err := belajar.OpenDatabase(belajar.DefaultDSN)
+	if err != nil {
+		panic(err)
+	}
+	keyring, err := belajar.NewKeyring("test", map[string][]byte{"test": bytes.Repeat([]byte("k"), 32)})
+	if err != nil {
+		panic(err)
+	}
+	belajar.SetKeyring(keyring)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.UnaryInterceptor(TenantInterceptor))
+	Register(server, db)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return conn
+}
+
+var conn = OpenClient()
+var users = pb.NewUserServiceClient(conn)
+var wallets = pb.NewWalletServiceClient(conn)
+
+// suffix keeps ids unique between runs without truncating tables
+var suffix = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+const tenant = "grpc-test"
+
+// tenantContext sends the tenant of the tests with a call
+func tenantContext() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), TenantMetadataKey, tenant)
+}
+
+func TestCreateUser(t *testing.T) {
+	ctx := tenantContext()
+	user, err := users.CreateUser(ctx, &pb.CreateUserRequest{
+		Id:       "grpc-user-" + suffix,
+		Password: "rahasia",
+		Name:     &pb.Name{FirstName: "Habibi", LastName: "Iberahim"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "grpc-user-"+suffix, user.Id)
+	assert.Equal(t, "Habibi", user.Name.FirstName)
+
+	_, err = users.CreateUser(ctx, &pb.CreateUserRequest{
+		Id:       "grpc-user-" + suffix,
+		Password: "rahasia",
+		Name:     &pb.Name{FirstName: "Habibi"},
+	})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = users.CreateUser(ctx, &pb.CreateUserRequest{Password: "123"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGetUpdateDeleteUser(t *testing.T) {
+	ctx := tenantContext()
+	id := "grpc-user-delete-" + suffix
+	_, err := users.CreateUser(ctx, &pb.CreateUserRequest{
+		Id:       id,
+		Password: "rahasia",
+		Name:     &pb.Name{FirstName: "Habibi"},
+	})
+	assert.Nil(t, err)
+
+	user, err := users.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:   id,
+		Name: &pb.Name{FirstName: "Habibi", MiddleName: "Updated"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "Updated", user.Name.MiddleName)
+
+	user, err = users.GetUser(ctx, &pb.GetUserRequest{Id: id})
+	assert.Nil(t, err)
+	assert.Equal(t, "Updated", user.Name.MiddleName)
+
+	_, err = users.DeleteUser(ctx, &pb.DeleteUserRequest{Id: id})
+	assert.Nil(t, err)
+
+	_, err = users.GetUser(ctx, &pb.GetUserRequest{Id: id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestListUsers(t *testing.T) {
+	ctx := tenantContext()
+	for _, id := range []string{"grpc-list-1-" + suffix, "grpc-list-2-" + suffix} {
+		_, err := users.CreateUser(ctx, &pb.CreateUserRequest{
+			Id:       id,
+			Password: "rahasia",
+			Name:     &pb.Name{FirstName: "Habibi"},
+		})
+		assert.Nil(t, err)
+	}
+
+	page, err := users.ListUsers(ctx, &pb.ListUsersRequest{PageSize: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(page.Users))
+	assert.NotEqual(t, "", page.NextPageToken)
+
+	next, err := users.ListUsers(ctx, &pb.ListUsersRequest{PageSize: 1, PageToken: page.NextPageToken})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(next.Users))
+	assert.Less(t, page.Users[0].Id, next.Users[0].Id)
+
+	_, err = users.ListUsers(ctx, &pb.ListUsersRequest{PageSize: 1000})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestTransfer(t *testing.T) {
+	ctx := tenantContext()
+	userId := "grpc-wallet-user-" + suffix
+	_, err := users.CreateUser(ctx, &pb.CreateUserRequest{
+		Id:       userId,
+		Password: "rahasia",
+		Name:     &pb.Name{FirstName: "Habibi"},
+	})
+	assert.Nil(t, err)
+
+	db, err := belajar.OpenDatabase(belajar.DefaultDSN)
+	assert.Nil(t, err)
+	err = db.WithContext(belajar.WithTenant(context.Background(), tenant)).Create([]belajar.Wallet{
+		{ID: "grpc-from-" + suffix, UserId: userId, Balance: 1000000},
+		{ID: "grpc-to-" + suffix, UserId: userId, Balance: 0},
+	}).Error
+	assert.Nil(t, err)
+
+	response, err := wallets.Transfer(ctx, &pb.TransferRequest{
+		FromWalletId: "grpc-from-" + suffix,
+		ToWalletId:   "grpc-to-" + suffix,
+		Amount:       250000,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(750000), response.From.Balance)
+	assert.Equal(t, int64(250000), response.To.Balance)
+
+	wallet, err := wallets.GetBalance(ctx, &pb.GetBalanceRequest{WalletId: "grpc-to-" + suffix})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(250000), wallet.Balance)
+
+	_, err = wallets.Transfer(ctx, &pb.TransferRequest{
+		FromWalletId: "grpc-to-" + suffix,
+		ToWalletId:   "grpc-from-" + suffix,
+		Amount:       300000,
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = wallets.Transfer(ctx, &pb.TransferRequest{
+		FromWalletId: "grpc-to-" + suffix,
+		ToWalletId:   "grpc-from-" + suffix,
+		Amount:       -1,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestMissingTenant(t *testing.T) {
+	_, err := users.GetUser(context.Background(), &pb.GetUserRequest{Id: "grpc-user-" + suffix})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = users.GetUser(metadata.AppendToOutgoingContext(context.Background(), TenantMetadataKey, "other"),
+		&pb.GetUserRequest{Id: "grpc-user-" + suffix})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
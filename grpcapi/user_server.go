@@ -0,0 +1,163 @@
+package grpcapi
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"habibiiberahim/belajar-golang-gorm/pb"
+	"strings"
+	"unicode/utf8"
+)
+
+type UserServer struct {
+	pb.UnimplementedUserServiceServer
+	db *gorm.DB
+}
+
+func (s *UserServer) CreateUser(ctx context.Context, request *pb.CreateUserRequest) (*pb.User, error) {
+	err := validateUser(request.GetId(), request.GetPassword(), request.GetName(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	user := belajar.User{
+		ID:       request.GetId(),
+		Password: belajar.Secret(request.GetPassword()),
+		Name:     toName(request.GetName()),
+	}
+	err = s.db.WithContext(ctx).Create(&user).Error
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toUser(user), nil
+}
+
+func (s *UserServer) GetUser(ctx context.Context, request *pb.GetUserRequest) (*pb.User, error) {
+	var user belajar.User
+	err := s.db.WithContext(ctx).Take(&user, "id = ?", request.GetId()).Error
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toUser(user), nil
+}
+
+func (s *UserServer) UpdateUser(ctx context.Context, request *pb.UpdateUserRequest) (*pb.User, error) {
+	err := validateUser(request.GetId(), request.GetPassword(), request.GetName(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{"first_name", "middle_name", "last_name"}
+	if request.GetPassword() != "" {
+		columns = append(columns, "password")
+	}
+
+	var user belajar.User
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Take(&user, "id = ?", request.GetId()).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&user).Select(columns).Updates(&belajar.User{
+			Password: belajar.Secret(request.GetPassword()),
+			Name:     toName(request.GetName()),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Take(&user, "id = ?", request.GetId()).Error
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toUser(user), nil
+}
+
+func (s *UserServer) DeleteUser(ctx context.Context, request *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	result := s.db.WithContext(ctx).Delete(&belajar.User{}, "id = ?", request.GetId())
+	if result.Error != nil {
+		return nil, toStatus(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, toStatus(gorm.ErrRecordNotFound)
+	}
+	return &pb.DeleteUserResponse{}, nil
+}
+
+// ListUsers pages users ordered by id, the page token is the encoded id of the last user
+func (s *UserServer) ListUsers(ctx context.Context, request *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	size := int(request.GetPageSize())
+	if size == 0 {
+		size = belajar.DefaultPageSize
+	}
+	if size < 0 || size > belajar.MaxPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must be between 1 and %d", belajar.MaxPageSize)
+	}
+
+	query := s.db.WithContext(ctx)
+	if request.GetPageToken() != "" {
+		lastId, err := decodePageToken(request.GetPageToken())
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("id > ?", lastId)
+	}
+
+	var users []belajar.User
+	err := query.Order("id asc").Limit(size + 1).Find(&users).Error
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	response := &pb.ListUsersResponse{}
+	if len(users) > size {
+		users = users[:size]
+		response.NextPageToken = encodePageToken(users[size-1].ID)
+	}
+	for _, user := range users {
+		response.Users = append(response.Users, toUser(user))
+	}
+	return response, nil
+}
+
+func validateUser(id string, password string, name *pb.Name, create bool) error {
+	switch {
+	case !create && id == "":
+		return status.Error(codes.InvalidArgument, "id is required")
+	case utf8.RuneCountInString(id) > 100:
+		return status.Error(codes.InvalidArgument, "id must be at most 100 characters")
+	case create && password == "":
+		return status.Error(codes.InvalidArgument, "password is required")
+	case password != "" && (utf8.RuneCountInString(password) < 6 || utf8.RuneCountInString(password) > 100):
+		return status.Error(codes.InvalidArgument, "password must be between 6 and 100 characters")
+	case strings.TrimSpace(name.GetFirstName()) == "":
+		return status.Error(codes.InvalidArgument, "name.first_name is required")
+	}
+	return nil
+}
+
+func toName(name *pb.Name) belajar.Name {
+	return belajar.Name{
+		FirstName:  name.GetFirstName(),
+		MiddleName: name.GetMiddleName(),
+		LastName:   name.GetLastName(),
+	}
+}
+
+// toUser goes through the dto so the password can never be copied to the response
+func toUser(user belajar.User) *pb.User {
+	dto := belajar.NewUserDTO(user)
+	return &pb.User{
+		Id: dto.ID,
+		Name: &pb.Name{
+			FirstName:  dto.Name.FirstName,
+			MiddleName: dto.Name.MiddleName,
+			LastName:   dto.Name.LastName,
+		},
+		CreatedAt: timestamppb.New(dto.CreatedAt),
+		UpdatedAt: timestamppb.New(dto.UpdatedAt),
+	}
+}
@@ -0,0 +1,42 @@
+package grpcapi
+
+import (
+	"context"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+	belajar "habibiiberahim/belajar-golang-gorm"
+	"habibiiberahim/belajar-golang-gorm/pb"
+)
+
+type WalletServer struct {
+	pb.UnimplementedWalletServiceServer
+	db *gorm.DB
+}
+
+func (s *WalletServer) GetBalance(ctx context.Context, request *pb.GetBalanceRequest) (*pb.Wallet, error) {
+	wallet, err := belajar.NewWalletService(s.db.WithContext(ctx)).Balance(request.GetWalletId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toWallet(wallet), nil
+}
+
+func (s *WalletServer) Transfer(ctx context.Context, request *pb.TransferRequest) (*pb.TransferResponse, error) {
+	from, to, err := belajar.NewWalletService(s.db.WithContext(ctx)).
+		Transfer(request.GetFromWalletId(), request.GetToWalletId(), request.GetAmount())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.TransferResponse{From: toWallet(from), To: toWallet(to)}, nil
+}
+
+func toWallet(wallet belajar.Wallet) *pb.Wallet {
+	dto := belajar.NewWalletDTO(wallet)
+	return &pb.Wallet{
+		Id:        dto.ID,
+		UserId:    dto.UserId,
+		Balance:   dto.Balance,
+		CreatedAt: timestamppb.New(dto.CreatedAt),
+		UpdatedAt: timestamppb.New(dto.UpdatedAt),
+	}
+}
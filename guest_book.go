@@ -2,15 +2,36 @@ package belajar_golang_gorm
 
 import "time"
 
+const (
+	GuestBookStatusPending  = "pending"
+	GuestBookStatusApproved = "approved"
+	GuestBookStatusRejected = "rejected"
+	GuestBookStatusSpam     = "spam"
+)
+
 type GuestBook struct {
-	ID        int64     `gorm:"primary_key;column:id;autoIncrement"`
-	Name      string    `gorm:"column:name"`
-	Email     string    `gorm:"column:email"`
-	Message   string    `gorm:"column:message"`
-	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
-	UpdatedAt time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+	ID          int64      `gorm:"primary_key;column:id;autoIncrement" json:"id"`
+	Name        string     `gorm:"column:name" json:"name"`
+	Email       string     `gorm:"column:email;size:512;serializer:encrypted;deterministic;index:guest_book_email_created_at_index,priority:1" json:"email"`
+	Message     string     `gorm:"column:message" json:"message"`
+	Status      string     `gorm:"column:status;type:varchar(20);default:pending;index" json:"status"`
+	ModeratedAt *time.Time `gorm:"column:moderated_at" json:"moderated_at,omitempty"`
+	CreatedAt   time.Time  `gorm:"column:created_at;autoCreateTime;index:guest_book_email_created_at_index,priority:2" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"column:updated_at;autoCreateTime;autoUpdateTime" json:"updated_at"`
+	TenantId    string     `gorm:"column:tenant_id;type:varchar(100);index" json:"-"`
 }
 
 func (g *GuestBook) TableName() string {
 	return "guest_book"
 }
+
+// GuestBookRateLimit has one row per email, it is locked while the messages of
+// the email are counted so concurrent posts from the same email wait in turn
+type GuestBookRateLimit struct {
+	Email     string    `gorm:"primary_key;column:email;size:512;serializer:encrypted;deterministic" json:"email"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (g *GuestBookRateLimit) TableName() string {
+	return "guest_book_rate_limits"
+}
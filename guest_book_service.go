@@ -0,0 +1,157 @@
+package belajar_golang_gorm
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidEmail         = errors.New("invalid email")
+	ErrEmptyMessage         = errors.New("message must not be empty")
+	ErrGuestBookRateLimited = errors.New("too many guest book messages from this email")
+)
+
+type GuestBookService struct {
+	db         *gorm.DB
+	classifier SpamClassifier
+	// MaxPosts messages are allowed from the same email within RateLimitWindow
+	MaxPosts        int64
+	RateLimitWindow time.Duration
+}
+
+func NewGuestBookService(db *gorm.DB, classifier SpamClassifier) *GuestBookService {
+	return &GuestBookService{
+		db:              db,
+		classifier:      classifier,
+		MaxPosts:        3,
+		RateLimitWindow: time.Hour,
+	}
+}
+
+// WithDB returns a copy of the service using db, ex: db.WithContext(ctx)
+func (s *GuestBookService) WithDB(db *gorm.DB) *GuestBookService {
+	service := *s
+	service.db = db
+	return &service
+}
+
+// Post validates the message and stores it as pending, or as spam when the
+// classifier says so. Only approved messages are listed.
+func (s *GuestBookService) Post(name string, email string, message string) (*GuestBook, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return nil, ErrInvalidEmail
+	}
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return nil, ErrEmptyMessage
+	}
+
+	guestBook := &GuestBook{
+		Name:    strings.TrimSpace(name),
+		Email:   email,
+		Message: message,
+		Status:  GuestBookStatusPending,
+	}
+	if s.classifier != nil {
+		spam, err := s.classifier.IsSpam(guestBook)
+		if err != nil {
+			return nil, err
+		}
+		if spam {
+			guestBook.Status = GuestBookStatusSpam
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// the count and the insert run under a lock on the email, otherwise
+		// concurrent posts all see the same count and pass MaxPosts
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&GuestBookRateLimit{Email: email}).Error
+		if err != nil {
+			return err
+		}
+		var lock GuestBookRateLimit
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&GuestBookRateLimit{Email: email}).Take(&lock).Error
+		if err != nil {
+			return err
+		}
+
+		// rate limit is counted from stored messages, so it is shared by every instance
+		var count int64
+		err = tx.Model(&GuestBook{}).
+			Scopes(EncryptedEquals("email", email)).
+			Where("created_at > ?", time.Now().Add(-s.RateLimitWindow)).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count >= s.MaxPosts {
+			return ErrGuestBookRateLimited
+		}
+
+		return tx.Create(guestBook).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return guestBook, nil
+}
+
+func (s *GuestBookService) Approve(id int64) error {
+	return s.moderate(id, GuestBookStatusApproved)
+}
+
+func (s *GuestBookService) Reject(id int64) error {
+	return s.moderate(id, GuestBookStatusRejected)
+}
+
+// ListApproved returns approved messages newest first. Pass 0 as cursor for the
+// first page and the returned next cursor for the following pages, next cursor
+// is 0 when there are no more messages.
+func (s *GuestBookService) ListApproved(cursor int64, limit int) ([]GuestBook, int64, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+	query := s.db.Where("status = ?", GuestBookStatusApproved)
+	if cursor > 0 {
+		query = query.Where("id < ?", cursor)
+	}
+
+	var guestBooks []GuestBook
+	err := query.Order("id desc").Limit(limit + 1).Find(&guestBooks).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next int64
+	if len(guestBooks) > limit {
+		guestBooks = guestBooks[:limit]
+		next = guestBooks[limit-1].ID
+	}
+	return guestBooks, next, nil
+}
+
+func (s *GuestBookService) ListPending(limit int) ([]GuestBook, error) {
+	var guestBooks []GuestBook
+	err := s.db.Where("status = ?", GuestBookStatusPending).Order("id asc").Limit(limit).Find(&guestBooks).Error
+	return guestBooks, err
+}
+
+func (s *GuestBookService) moderate(id int64, status string) error {
+	result := s.db.Model(&GuestBook{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":       status,
+		"moderated_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
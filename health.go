@@ -0,0 +1,252 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultMaxReplicationLag = 30 * time.Second
+
+var ErrReplicationNotRunning = errors.New("replication is not running")
+
+type HealthStatus string
+
+const (
+	HealthOK   HealthStatus = "ok"
+	HealthFail HealthStatus = "fail"
+)
+
+// RequiredModels are the tables the application can not serve requests without
+var RequiredModels = []interface{}{
+	&User{}, &UserLog{}, &Wallet{}, &Address{}, &Todo{},
+	&Product{}, &ProductPrice{}, &UserLikeProduct{}, &GuestBook{}, &GuestBookRateLimit{},
+}
+
+type HealthCheck struct {
+	Name     string       `json:"name"`
+	Status   HealthStatus `json:"status"`
+	Duration string       `json:"duration"`
+	Error    string       `json:"error,omitempty"`
+	// ReplicationLag is only set on the check of a replica
+	ReplicationLag string `json:"replication_lag,omitempty"`
+}
+
+type HealthReport struct {
+	Status HealthStatus  `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+type HealthOptions struct {
+	// Models are checked to have a table with every column, RequiredModels when nil
+	Models []interface{}
+	// Replicas by name, their replication lag is reported
+	Replicas map[string]*gorm.DB
+	// MaxReplicationLag fails replicas lagging further, DefaultMaxReplicationLag when zero
+	MaxReplicationLag time.Duration
+}
+
+type HealthChecker struct {
+	db      *gorm.DB
+	options HealthOptions
+}
+
+func NewHealthChecker(db *gorm.DB, options HealthOptions) *HealthChecker {
+	if options.Models == nil {
+		options.Models = RequiredModels
+	}
+	if options.MaxReplicationLag <= 0 {
+		options.MaxReplicationLag = DefaultMaxReplicationLag
+	}
+	return &HealthChecker{db: db, options: options}
+}
+
+// Live only pings the pool
+func (h *HealthChecker) Live(ctx context.Context) HealthReport {
+	return newHealthReport(h.check("ping", func() (string, error) { return "", ping(ctx, h.db) }))
+}
+
+// Health pings the pool, checks the required tables exist with every column of
+// their model and reports the replication lag of the replicas. The checks run
+// without tenant and policy so they pass with TenantPlugin and PolicyPlugin.
+func (h *HealthChecker) Health(ctx context.Context) HealthReport {
+	ctx = WithoutPolicy(WithoutTenant(ctx))
+	db := h.db.WithContext(ctx)
+	checks := []HealthCheck{
+		h.check("ping", func() (string, error) { return "", ping(ctx, h.db) }),
+		h.check("tables", func() (string, error) { return "", h.checkTables(db) }),
+		h.check("migrations", func() (string, error) { return "", h.checkMigrations(db) }),
+	}
+
+	names := make([]string, 0, len(h.options.Replicas))
+	for name := range h.options.Replicas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		replica := h.options.Replicas[name].WithContext(ctx)
+		checks = append(checks, h.check("replica:"+name, func() (string, error) {
+			return h.checkReplica(ctx, replica)
+		}))
+	}
+	return newHealthReport(checks...)
+}
+
+func (h *HealthChecker) check(name string, fn func() (string, error)) HealthCheck {
+	begin := time.Now()
+	lag, err := fn()
+	check := HealthCheck{Name: name, Status: HealthOK, Duration: time.Since(begin).String(), ReplicationLag: lag}
+	if err != nil {
+		check.Status = HealthFail
+		check.Error = err.Error()
+	}
+	return check
+}
+
+func newHealthReport(checks ...HealthCheck) HealthReport {
+	report := HealthReport{Status: HealthOK, Checks: checks}
+	for _, check := range checks {
+		if check.Status != HealthOK {
+			report.Status = HealthFail
+		}
+	}
+	return report
+}
+
+func ping(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (h *HealthChecker) checkTables(db *gorm.DB) error {
+	var missing []string
+	for _, model := range h.options.Models {
+		if !db.Migrator().HasTable(model) {
+			missing = append(missing, tableName(db, model))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkMigrations reports the columns of the models missing in their tables,
+// they are the changes a migration has not applied yet
+func (h *HealthChecker) checkMigrations(db *gorm.DB) error {
+	var pending []string
+	for _, model := range h.options.Models {
+		statement := &gorm.Statement{DB: db}
+		err := statement.Parse(model)
+		if err != nil {
+			return err
+		}
+		if !db.Migrator().HasTable(model) {
+			continue
+		}
+
+		columnTypes, err := db.Migrator().ColumnTypes(model)
+		if err != nil {
+			return err
+		}
+		columns := make(map[string]bool, len(columnTypes))
+		for _, columnType := range columnTypes {
+			columns[strings.ToLower(columnType.Name())] = true
+		}
+		for _, name := range statement.Schema.DBNames {
+			if !columns[strings.ToLower(name)] {
+				pending = append(pending, statement.Table+"."+name)
+			}
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("pending migrations, missing columns %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// checkReplica reads the lag from SHOW REPLICA STATUS, servers older than
+// mysql 8.0.22 only know SHOW SLAVE STATUS
+func (h *HealthChecker) checkReplica(ctx context.Context, replica *gorm.DB) (string, error) {
+	if err := ping(ctx, replica); err != nil {
+		return "", err
+	}
+
+	rows, err := replica.Raw("SHOW REPLICA STATUS").Rows()
+	if err != nil {
+		rows, err = replica.Raw("SHOW SLAVE STATUS").Rows()
+	}
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	status, err := scanTextRows(rows)
+	if err != nil {
+		return "", err
+	}
+	if len(status) == 0 {
+		return "", ErrReplicationNotRunning
+	}
+
+	seconds, ok := status[0]["Seconds_Behind_Source"]
+	if !ok {
+		seconds, ok = status[0]["Seconds_Behind_Master"]
+	}
+	if !ok {
+		return "", ErrReplicationNotRunning
+	}
+	value, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid replication lag %q", seconds)
+	}
+
+	lag := time.Duration(value) * time.Second
+	if lag > h.options.MaxReplicationLag {
+		return lag.String(), fmt.Errorf("replication lag %s is over %s", lag, h.options.MaxReplicationLag)
+	}
+	return lag.String(), nil
+}
+
+func tableName(db *gorm.DB, model interface{}) string {
+	statement := &gorm.Statement{DB: db}
+	if err := statement.Parse(model); err != nil {
+		return fmt.Sprintf("%T", model)
+	}
+	return statement.Table
+}
+
+// LivenessHandler serves /healthz, it only fails when the pool can not be pinged
+func (h *HealthChecker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, h.Live(r.Context()))
+	})
+}
+
+// ReadinessHandler serves /readyz, it fails when any check of Health fails
+func (h *HealthChecker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, h.Health(r.Context()))
+	})
+}
+
+func writeHealthReport(w http.ResponseWriter, report HealthReport) {
+	status := http.StatusOK
+	if report.Status != HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,417 @@
+package belajar_golang_gorm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrUnknownImportFormat = errors.New("unknown import format")
+var ErrInvalidImportHeader = errors.New("invalid import header")
+var ErrInvalidConflictColumns = errors.New("invalid conflict columns")
+
+type ImportFormat string
+
+const (
+	ImportCSV   ImportFormat = "csv"
+	ImportJSONL ImportFormat = "jsonl"
+)
+
+type ConflictStrategy string
+
+const (
+	// ConflictSkip keeps the existing row
+	ConflictSkip ConflictStrategy = "skip"
+	// ConflictUpdateAll overwrites every imported column of the existing row
+	ConflictUpdateAll ConflictStrategy = "update-all"
+	// ConflictUpdateColumns overwrites only ImportOptions.Columns, they must be imported columns
+	ConflictUpdateColumns ConflictStrategy = "update-columns"
+)
+
+type ImportOptions struct {
+	Format    ImportFormat
+	ChunkSize int
+	Conflict  ConflictStrategy
+	Columns   []string
+}
+
+type ImportRowError struct {
+	Line    int               `json:"line"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// ImportReport counts rows by outcome, Skipped is only counted with ConflictSkip
+// because mysql does not report which rows of an upsert were updated
+type ImportReport struct {
+	Total    int              `json:"total"`
+	Imported int              `json:"imported"`
+	Skipped  int              `json:"skipped"`
+	Failed   int              `json:"failed"`
+	Errors   []ImportRowError `json:"errors,omitempty"`
+}
+
+// Importer upserts records read from CSV or JSON lines in chunks. Invalid rows
+// and rows rejected by the database are reported and the import goes on.
+type Importer[T any] struct {
+	db       *gorm.DB
+	columns  []string
+	fromCSV  func(record map[string]string) (T, map[string]string)
+	fromJSON func(data []byte) (T, error)
+	validate func(item *T) map[string]string
+	// beforeUpsert runs in the transaction of the upsert of a chunk or a row
+	beforeUpsert func(tx *gorm.DB, items []T, conflict clause.OnConflict) error
+}
+
+// importRecord is a JSON line of an import, it lists only the documented columns
+// so associations and columns owned by the database can not be imported
+type importRecord[T any] interface {
+	model() T
+}
+
+type userImportRecord struct {
+	ID       string `json:"id"`
+	Password Secret `json:"password"`
+	Name     Name   `json:"name"`
+}
+
+func (r userImportRecord) model() User {
+	return User{ID: r.ID, Password: r.Password, Name: r.Name}
+}
+
+type productImportRecord struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Price int64  `json:"price"`
+}
+
+func (r productImportRecord) model() Product {
+	return Product{ID: r.ID, Name: r.Name, Price: r.Price}
+}
+
+// fromJSON decodes a JSON line into the record R, unknown fields are rejected
+func fromJSON[T any, R importRecord[T]](data []byte) (T, error) {
+	var record R
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&record); err != nil {
+		var zero T
+		return zero, err
+	}
+	return record.model(), nil
+}
+
+type importRow[T any] struct {
+	line int
+	item T
+}
+
+// NewUserImporter reads the CSV columns id, password, first_name, middle_name and last_name
+// or JSON lines with id, password and name. The id is required so rows can be upserted.
+func NewUserImporter(db *gorm.DB) *Importer[User] {
+	return &Importer[User]{
+		db:      db,
+		columns: []string{"id", "password", "first_name", "middle_name", "last_name"},
+		fromCSV: func(record map[string]string) (User, map[string]string) {
+			return User{
+				ID:       record["id"],
+				Password: Secret(record["password"]),
+				Name: Name{
+					FirstName:  record["first_name"],
+					MiddleName: record["middle_name"],
+					LastName:   record["last_name"],
+				},
+			}, nil
+		},
+		fromJSON: fromJSON[User, userImportRecord],
+		validate: func(user *User) map[string]string {
+			fields := ValidateUser(user)
+			validator(fields).required("id", user.ID)
+			return fields
+		},
+	}
+}
+
+// NewProductImporter reads the CSV columns id, name and price or JSON lines with
+// the same fields. Prices of existing products are changed with the PriceService
+// so they are kept in the price history.
+func NewProductImporter(db *gorm.DB) *Importer[Product] {
+	return &Importer[Product]{
+		db:      db,
+		columns: []string{"id", "name", "price"},
+		fromCSV: func(record map[string]string) (Product, map[string]string) {
+			price, err := strconv.ParseInt(strings.TrimSpace(record["price"]), 10, 64)
+			if err != nil {
+				return Product{}, map[string]string{"price": "must be a number"}
+			}
+			return Product{ID: record["id"], Name: record["name"], Price: price}, nil
+		},
+		fromJSON:     fromJSON[Product, productImportRecord],
+		validate:     ValidateProduct,
+		beforeUpsert: changeImportedPrices,
+	}
+}
+
+// changeImportedPrices changes the prices the upsert would overwrite through
+// the price service, new products start without price history like in the api
+func changeImportedPrices(tx *gorm.DB, products []Product, conflict clause.OnConflict) error {
+	if !conflictUpdates(conflict, "price") {
+		return nil
+	}
+	ids := make([]string, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	var existing []Product
+	err := tx.Where("id IN ?", ids).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	prices := make(map[string]int64, len(existing))
+	for _, product := range existing {
+		prices[product.ID] = product.Price
+	}
+
+	service := NewPriceService(tx)
+	for _, product := range products {
+		if price, ok := prices[product.ID]; ok && price != product.Price {
+			if err = service.ChangePrice(product.ID, product.Price); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func conflictUpdates(conflict clause.OnConflict, column string) bool {
+	for _, assignment := range conflict.DoUpdates {
+		if assignment.Column.Name == column {
+			return true
+		}
+	}
+	return false
+}
+
+// Import reads every record of r. The returned error is only set when the input
+// can not be read at all, e.g. a bad CSV header, rows failing are in the report.
+func (im *Importer[T]) Import(r io.Reader, options ImportOptions) (*ImportReport, error) {
+	conflict, err := im.conflictClause(options)
+	if err != nil {
+		return nil, err
+	}
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = DefaultBatchSize
+	}
+
+	report := &ImportReport{}
+	var chunk []importRow[T]
+	add := func(line int, item T, fields map[string]string, err error) {
+		report.Total++
+		if err == nil && fields == nil {
+			fields = im.validate(&item)
+		}
+		if err != nil || len(fields) > 0 {
+			report.fail(line, err, fields)
+			return
+		}
+
+		chunk = append(chunk, importRow[T]{line: line, item: item})
+		if len(chunk) == options.ChunkSize {
+			im.flush(chunk, conflict, options.Conflict, report)
+			chunk = chunk[:0]
+		}
+	}
+
+	switch options.Format {
+	case ImportCSV:
+		err = im.readCSV(r, add)
+	case ImportJSONL:
+		err = im.readJSONL(r, add)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownImportFormat, options.Format)
+	}
+	if len(chunk) > 0 {
+		im.flush(chunk, conflict, options.Conflict, report)
+	}
+	return report, err
+}
+
+func (im *Importer[T]) conflictClause(options ImportOptions) (clause.OnConflict, error) {
+	var columns []string
+	switch options.Conflict {
+	case ConflictSkip, "":
+		return clause.OnConflict{DoNothing: true}, nil
+	case ConflictUpdateAll:
+		// not clause.OnConflict{UpdateAll: true}, it also overwrites the columns
+		// that are not imported, like like_count, tenant_id and created_at
+		columns = im.columns
+	case ConflictUpdateColumns:
+		if len(options.Columns) == 0 {
+			return clause.OnConflict{}, ErrInvalidConflictColumns
+		}
+		columns = options.Columns
+	default:
+		return clause.OnConflict{}, fmt.Errorf("unknown conflict strategy %q", options.Conflict)
+	}
+
+	statement := &gorm.Statement{DB: im.db}
+	err := statement.Parse(new(T))
+	if err != nil {
+		return clause.OnConflict{}, err
+	}
+	updates := make([]string, 0, len(columns))
+	for _, column := range columns {
+		field := statement.Schema.LookUpField(column)
+		if field == nil || field.DBName == "" || !slices.Contains(im.columns, field.DBName) {
+			return clause.OnConflict{}, fmt.Errorf("%w: %q", ErrInvalidConflictColumns, column)
+		}
+		if field.PrimaryKey {
+			if options.Conflict == ConflictUpdateColumns {
+				return clause.OnConflict{}, fmt.Errorf("%w: %q", ErrInvalidConflictColumns, column)
+			}
+			continue
+		}
+		updates = append(updates, field.DBName)
+	}
+	return clause.OnConflict{DoUpdates: clause.AssignmentColumns(updates)}, nil
+}
+
+// flush upserts the chunk in one statement, when it fails the rows are upserted
+// one by one so only the rows rejected by the database are reported
+func (im *Importer[T]) flush(chunk []importRow[T], conflict clause.OnConflict, strategy ConflictStrategy, report *ImportReport) {
+	items := make([]T, len(chunk))
+	for i, row := range chunk {
+		items[i] = row.item
+	}
+
+	affected, err := im.upsert(items, conflict)
+	if err == nil {
+		im.count(len(items), affected, strategy, report)
+		return
+	}
+	for _, row := range chunk {
+		affected, err = im.upsert([]T{row.item}, conflict)
+		if err != nil {
+			report.fail(row.line, err, nil)
+			continue
+		}
+		im.count(1, affected, strategy, report)
+	}
+}
+
+func (im *Importer[T]) upsert(items []T, conflict clause.OnConflict) (int64, error) {
+	var affected int64
+	err := im.db.Transaction(func(tx *gorm.DB) error {
+		if im.beforeUpsert != nil {
+			// the hook joins this transaction instead of opening a savepoint
+			err := im.beforeUpsert(tx.Session(&gorm.Session{DisableNestedTransaction: true}), items, conflict)
+			if err != nil {
+				return err
+			}
+		}
+		result := tx.Omit(clause.Associations).Clauses(conflict).Create(&items)
+		affected = result.RowsAffected
+		return result.Error
+	})
+	return affected, err
+}
+
+func (im *Importer[T]) count(rows int, affected int64, strategy ConflictStrategy, report *ImportReport) {
+	if strategy == ConflictSkip || strategy == "" {
+		report.Imported += int(affected)
+		report.Skipped += rows - int(affected)
+		return
+	}
+	report.Imported += rows
+}
+
+func (im *Importer[T]) readCSV(r io.Reader, add func(line int, item T, fields map[string]string, err error)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImportHeader, err)
+	}
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if !slices.Contains(im.columns, header[i]) {
+			return fmt.Errorf("%w: unknown column %q, expected %s", ErrInvalidImportHeader, column, strings.Join(im.columns, ", "))
+		}
+	}
+
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		var zero T
+		var parseError *csv.ParseError
+		switch {
+		case errors.As(err, &parseError):
+			add(parseError.StartLine, zero, nil, parseError.Err)
+		case err != nil:
+			return err
+		case len(values) != len(header):
+			line, _ := reader.FieldPos(0)
+			add(line, zero, nil, fmt.Errorf("expected %d columns, got %d", len(header), len(values)))
+		default:
+			line, _ := reader.FieldPos(0)
+			record := make(map[string]string, len(header))
+			for i, column := range header {
+				record[column] = values[i]
+			}
+			item, fields := im.fromCSV(record)
+			add(line, item, fields, nil)
+		}
+	}
+}
+
+func (im *Importer[T]) readJSONL(r io.Reader, add func(line int, item T, fields map[string]string, err error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		item, err := im.fromJSON(data)
+		add(line, item, nil, err)
+	}
+	return scanner.Err()
+}
+
+func (report *ImportReport) fail(line int, err error, fields map[string]string) {
+	report.Failed++
+	rowError := ImportRowError{Line: line, Fields: fields}
+	if err != nil {
+		rowError.Message = err.Error()
+	} else {
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rowError.Message = "invalid " + strings.Join(names, ", ")
+	}
+	report.Errors = append(report.Errors, rowError)
+}
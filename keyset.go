@@ -0,0 +1,285 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidKeysetOrder = errors.New("invalid keyset order")
+var ErrNullKeysetValue = errors.New("keyset column is null")
+
+// KeysetPage holds the opaque cursors of the pages around the current page,
+// a cursor is empty when there is no page in that direction
+type KeysetPage struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// Keyset paginates by the values of the order columns instead of an offset, so
+// pages stay fast on large tables and rows are not skipped by concurrent inserts.
+// The order must end with a unique column, e.g. "created_at desc, id desc", and
+// its columns must not be null or encrypted. A column of a relationship loaded with Joins is
+// written with the relationship name, e.g. "Wallet.balance desc, users.id asc",
+// a row without the relationship fails with ErrNullKeysetValue, so filter those
+// rows out, e.g. Where("Wallet.id IS NOT NULL").
+type Keyset struct {
+	columns []keysetColumn
+}
+
+type keysetColumn struct {
+	table string
+	name  string
+	desc  bool
+}
+
+type keysetCursor struct {
+	Values   []json.RawMessage `json:"v"`
+	Backward bool              `json:"b,omitempty"`
+}
+
+func NewKeyset(order string) (*Keyset, error) {
+	var columns []keysetColumn
+	for _, part := range strings.Split(order, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidKeysetOrder, order)
+		}
+
+		column := keysetColumn{name: fields[0]}
+		if len(fields) == 2 {
+			switch strings.ToLower(fields[1]) {
+			case "asc":
+			case "desc":
+				column.desc = true
+			default:
+				return nil, fmt.Errorf("%w: unknown direction %q", ErrInvalidKeysetOrder, fields[1])
+			}
+		}
+		if table, name, ok := strings.Cut(column.name, "."); ok {
+			column.table, column.name = table, name
+		}
+		columns = append(columns, column)
+	}
+	return &Keyset{columns: columns}, nil
+}
+
+// Scope filters the rows after the cursor, orders them and fetches one row more
+// than limit to know whether another page exists. Rows of a backward cursor are
+// returned in reverse order, use KeysetPaginate to get pages in the keyset order.
+func (k *Keyset) Scope(cursor string, limit int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		fields, err := k.fields(db)
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+
+		var decoded keysetCursor
+		if cursor != "" {
+			decoded, err = decodeKeysetCursor(cursor, fields)
+			if err != nil {
+				db.AddError(err)
+				return db
+			}
+		}
+
+		orders := make([]clause.OrderByColumn, len(k.columns))
+		for i, column := range k.columns {
+			orders[i] = clause.OrderByColumn{Column: fields[i].column, Desc: column.desc != decoded.Backward}
+		}
+		db = db.Clauses(clause.OrderBy{Columns: orders})
+
+		if cursor != "" {
+			values := make([]interface{}, len(fields))
+			for i, field := range fields {
+				value := reflect.New(field.field.FieldType)
+				_ = json.Unmarshal(decoded.Values[i], value.Interface())
+				values[i] = value.Elem().Interface()
+			}
+			db = db.Where(keysetCondition(orders, values))
+		}
+		return db.Limit(limit + 1)
+	}
+}
+
+// KeysetPaginate returns a page of at most limit rows after the cursor, an empty
+// cursor returns the first page
+func KeysetPaginate[T any](db *gorm.DB, keyset *Keyset, cursor string, limit int) ([]T, KeysetPage, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	var results []T
+	query := db.Scopes(keyset.Scope(cursor, limit)).Find(&results)
+	if query.Error != nil {
+		return nil, KeysetPage{}, query.Error
+	}
+	fields, err := keyset.fields(query)
+	if err != nil {
+		return nil, KeysetPage{}, err
+	}
+
+	backward := false
+	if cursor != "" {
+		decoded, err := decodeKeysetCursor(cursor, fields)
+		if err != nil {
+			return nil, KeysetPage{}, err
+		}
+		backward = decoded.Backward
+	}
+
+	more := len(results) > limit
+	if more {
+		results = results[:limit]
+	}
+	if backward {
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+	}
+	if len(results) == 0 {
+		return results, KeysetPage{}, nil
+	}
+
+	ctx := query.Statement.Context
+	var page KeysetPage
+	if more || backward {
+		page.Next, err = encodeKeysetCursor(ctx, fields, reflect.ValueOf(&results[len(results)-1]), false)
+		if err != nil {
+			return nil, KeysetPage{}, err
+		}
+	}
+	if (backward && more) || (!backward && cursor != "") {
+		page.Prev, err = encodeKeysetCursor(ctx, fields, reflect.ValueOf(&results[0]), true)
+		if err != nil {
+			return nil, KeysetPage{}, err
+		}
+	}
+	return results, page, nil
+}
+
+type keysetField struct {
+	column   clause.Column
+	relation *schema.Relationship
+	field    *schema.Field
+}
+
+// fields resolves the order columns against the schema of the queried model
+func (k *Keyset) fields(db *gorm.DB) ([]keysetField, error) {
+	model := db.Statement.Model
+	if model == nil {
+		model = db.Statement.Dest
+	}
+	if err := db.Statement.Parse(model); err != nil {
+		return nil, err
+	}
+	modelSchema := db.Statement.Schema
+
+	fields := make([]keysetField, len(k.columns))
+	for i, column := range k.columns {
+		fieldSchema := modelSchema
+		if column.table != "" && column.table != modelSchema.Table {
+			relation, ok := modelSchema.Relationships.Relations[column.table]
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown table %q", ErrInvalidKeysetOrder, column.table)
+			}
+			fields[i].relation = relation
+			fieldSchema = relation.FieldSchema
+		}
+
+		field := fieldSchema.LookUpField(column.name)
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidKeysetOrder, column.name)
+		}
+		if _, ok := field.Serializer.(EncryptedSerializer); ok {
+			return nil, fmt.Errorf("%w: %s", ErrEncryptedColumn, column.name)
+		}
+		fields[i].field = field
+		if fields[i].relation != nil {
+			fields[i].column = clause.Column{Table: fields[i].relation.Name, Name: field.DBName}
+		} else {
+			fields[i].column = clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+		}
+	}
+	return fields, nil
+}
+
+// keysetCondition expands the row comparison so columns can have different
+// directions: (a > ?) OR (a = ? AND b < ?) OR ... The result is wrapped with
+// And because gorm joins a single Or condition to the previous conditions with OR.
+func keysetCondition(orders []clause.OrderByColumn, values []interface{}) clause.Expression {
+	var or []clause.Expression
+	for i, order := range orders {
+		var and []clause.Expression
+		for j := 0; j < i; j++ {
+			and = append(and, clause.Eq{Column: orders[j].Column, Value: values[j]})
+		}
+		if order.Desc {
+			and = append(and, clause.Lt{Column: order.Column, Value: values[i]})
+		} else {
+			and = append(and, clause.Gt{Column: order.Column, Value: values[i]})
+		}
+		or = append(or, clause.And(and...))
+	}
+	return clause.And(clause.Or(or...))
+}
+
+func encodeKeysetCursor(ctx context.Context, fields []keysetField, row reflect.Value, backward bool) (string, error) {
+	row = reflect.Indirect(row)
+	cursor := keysetCursor{Values: make([]json.RawMessage, len(fields)), Backward: backward}
+	for i, field := range fields {
+		value := row
+		if field.relation != nil {
+			value = reflect.Indirect(field.relation.Field.ReflectValueOf(ctx, row))
+			// a LEFT JOIN without a match leaves the relationship nil, or zero
+			// when it is not a pointer
+			if primary := field.relation.FieldSchema.PrioritizedPrimaryField; value.IsValid() && primary != nil {
+				if _, zero := primary.ValueOf(ctx, value); zero {
+					value = reflect.Value{}
+				}
+			}
+			if !value.IsValid() {
+				return "", fmt.Errorf("%w: %s.%s", ErrNullKeysetValue, field.relation.Name, field.field.DBName)
+			}
+		}
+		fieldValue, _ := field.field.ValueOf(ctx, value)
+
+		encoded, err := json.Marshal(fieldValue)
+		if err != nil {
+			return "", err
+		}
+		cursor.Values[i] = encoded
+	}
+
+	encoded, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+func decodeKeysetCursor(cursor string, fields []keysetField) (keysetCursor, error) {
+	var decoded keysetCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return decoded, ErrInvalidCursor
+	}
+	if json.Unmarshal(data, &decoded) != nil || len(decoded.Values) != len(fields) {
+		return decoded, ErrInvalidCursor
+	}
+	for i, field := range fields {
+		if json.Unmarshal(decoded.Values[i], reflect.New(field.field.FieldType).Interface()) != nil {
+			return decoded, ErrInvalidCursor
+		}
+	}
+	return decoded, nil
+}
@@ -0,0 +1,148 @@
+package belajar_golang_gorm
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type LikeService struct {
+	db *gorm.DB
+}
+
+func NewLikeService(db *gorm.DB) *LikeService {
+	return &LikeService{db: db}
+}
+
+// Like is idempotent, liking the same product twice only counts once
+func (s *LikeService) Like(userId string, productId string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return like(tx, userId, productId)
+	})
+}
+
+// Unlike is idempotent, unliking a product that is not liked does nothing
+func (s *LikeService) Unlike(userId string, productId string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return unlike(tx, userId, productId)
+	})
+}
+
+// Toggle likes the product when it is not liked yet, otherwise unlikes it.
+// It returns true when the product is liked after the call.
+func (s *LikeService) Toggle(userId string, productId string) (bool, error) {
+	var liked bool
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&UserLikeProduct{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND product_id = ?", userId, productId).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			liked = false
+			return unlike(tx, userId, productId)
+		}
+		liked = true
+		return like(tx, userId, productId)
+	})
+	return liked, err
+}
+
+// RecentLikes returns likes of the product created in the last days, newest first
+func (s *LikeService) RecentLikes(productId string, days int) ([]UserLikeProduct, error) {
+	var likes []UserLikeProduct
+	err := s.db.Scopes(LikedInLastDays(days)).
+		Where("product_id = ?", productId).
+		Order("created_at desc").
+		Find(&likes).Error
+	return likes, err
+}
+
+// RecentlyLikedProducts returns products the user liked in the last days, newest like first
+func (s *LikeService) RecentlyLikedProducts(userId string, days int) ([]Product, error) {
+	var products []Product
+	err := s.db.Joins("join (?) user_like_product on user_like_product.product_id = products.id", s.likes()).
+		Scopes(LikedInLastDays(days)).
+		Where("user_like_product.user_id = ?", userId).
+		Order("user_like_product.created_at desc").
+		Find(&products).Error
+	return products, err
+}
+
+func (s *LikeService) IsLiked(userId string, productId string) (bool, error) {
+	var count int64
+	err := s.db.Model(&UserLikeProduct{}).
+		Where("user_id = ? AND product_id = ?", userId, productId).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// MostLiked ranks products by like count. When since is not zero only likes
+// created after since are counted, otherwise the denormalized like_count is used.
+func (s *LikeService) MostLiked(limit int, since time.Time) ([]Product, error) {
+	var products []Product
+	if since.IsZero() {
+		err := s.db.Where("like_count > ?", 0).
+			Order("like_count desc, id asc").
+			Limit(limit).
+			Find(&products).Error
+		return products, err
+	}
+
+	err := s.db.Joins("join (?) user_like_product on user_like_product.product_id = products.id "+
+		"AND user_like_product.created_at >= ?", s.likes(), since).
+		Group("products.id").
+		Order("count(user_like_product.user_id) desc, products.id asc").
+		Limit(limit).
+		Find(&products).Error
+	return products, err
+}
+
+// AlsoLiked returns products liked by users who also liked the given product,
+// ordered by how many of those users liked them
+func (s *LikeService) AlsoLiked(productId string, limit int) ([]Product, error) {
+	var products []Product
+	err := s.db.Joins("join (?) other on other.product_id = products.id", s.likes()).
+		Joins("join (?) mine on mine.user_id = other.user_id AND mine.product_id = ?", s.likes(), productId).
+		Where("products.id <> ?", productId).
+		Group("products.id").
+		Order("count(other.user_id) desc, products.id asc").
+		Limit(limit).
+		Find(&products).Error
+	return products, err
+}
+
+// likes is joined as a subquery instead of the user_like_product table, so the
+// TenantPlugin filters it like the products
+func (s *LikeService) likes() *gorm.DB {
+	return s.db.Model(&UserLikeProduct{})
+}
+
+// RecountLikes rebuilds like_count from user_like_product, use it after
+// writing likes without the UserLikeProduct model, ex: with db.Table("user_like_product")
+// or raw sql, since only the model hooks keep like_count in sync
+func (s *LikeService) RecountLikes() error {
+	return s.db.Session(&gorm.Session{AllowGlobalUpdate: true}).
+		Model(&Product{}).
+		UpdateColumn("like_count", gorm.Expr("(select count(*) from user_like_product "+
+			"where user_like_product.product_id = products.id "+
+			"AND user_like_product.tenant_id = products.tenant_id)")).Error
+}
+
+// like inserts the like with ON CONFLICT DO NOTHING so liking twice is not an
+// error, like_count is kept in sync by the UserLikeProduct hooks
+func like(tx *gorm.DB, userId string, productId string) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&UserLikeProduct{
+			UserId:    userId,
+			ProductId: productId,
+		}).Error
+}
+
+func unlike(tx *gorm.DB, userId string, productId string) error {
+	return tx.Where("user_id = ? AND product_id = ?", userId, productId).Delete(&UserLikeProduct{}).Error
+}
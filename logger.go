@@ -0,0 +1,209 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const DefaultSlowThreshold = 200 * time.Millisecond
+
+type SlogLoggerConfig struct {
+	LogLevel      logger.LogLevel
+	SlowThreshold time.Duration
+	// LogValues writes the bind variables into the logged sql, by default they
+	// are left as ? placeholders. Secret values are redacted either way.
+	LogValues                 bool
+	IgnoreRecordNotFoundError bool
+}
+
+// SlogLogger is a gorm logger writing structured log/slog records. Use it as
+// the Logger of gorm.Config and install it with db.Use so slow queries are
+// logged together with their EXPLAIN plan.
+type SlogLogger struct {
+	logger *slog.Logger
+	config SlogLoggerConfig
+	db     *gorm.DB
+}
+
+func NewSlogLogger(logger *slog.Logger, config SlogLoggerConfig) *SlogLogger {
+	return &SlogLogger{logger: logger, config: config}
+}
+
+type traceStatementKey struct{}
+
+// traceStatement keeps the sql and bind variables of a statement so a slow
+// query can be explained with its real values while the log stays redacted
+type traceStatement struct {
+	sql  string
+	vars []interface{}
+}
+
+func (l *SlogLogger) Name() string {
+	return "slog_logger"
+}
+
+func (l *SlogLogger) Initialize(db *gorm.DB) error {
+	l.db = db
+	trace := func(tx *gorm.DB) {
+		tx.Statement.Context = context.WithValue(tx.Statement.Context, traceStatementKey{}, &traceStatement{})
+	}
+
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Create().Before("*").Register("slog_logger:trace", trace),
+		callback.Query().Before("*").Register("slog_logger:trace", trace),
+		callback.Update().Before("*").Register("slog_logger:trace", trace),
+		callback.Delete().Before("*").Register("slog_logger:trace", trace),
+		callback.Row().Before("*").Register("slog_logger:trace", trace),
+		callback.Raw().Before("*").Register("slog_logger:trace", trace),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *SlogLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copied := *l
+	copied.config.LogLevel = level
+	return &copied
+}
+
+func (l *SlogLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.config.LogLevel >= logger.Info {
+		l.logger.InfoContext(ctx, fmt.Sprintf(msg, data...), slog.String("caller", utils.FileWithLineNum()))
+	}
+}
+
+func (l *SlogLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.config.LogLevel >= logger.Warn {
+		l.logger.WarnContext(ctx, fmt.Sprintf(msg, data...), slog.String("caller", utils.FileWithLineNum()))
+	}
+}
+
+func (l *SlogLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.config.LogLevel >= logger.Error {
+		l.logger.ErrorContext(ctx, fmt.Sprintf(msg, data...), slog.String("caller", utils.FileWithLineNum()))
+	}
+}
+
+// ParamsFilter is called by gorm before the sql is logged, the bind variables
+// returned here are the ones written into the logged sql
+func (l *SlogLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if statement, ok := ctx.Value(traceStatementKey{}).(*traceStatement); ok {
+		statement.sql = sql
+		statement.vars = params
+	}
+	if l.config.LogValues {
+		return sql, params
+	}
+	return sql, nil
+}
+
+func (l *SlogLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.config.LogLevel <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	slow := l.config.SlowThreshold > 0 && elapsed > l.config.SlowThreshold
+	failed := err != nil && !(l.config.IgnoreRecordNotFoundError && errors.Is(err, gorm.ErrRecordNotFound))
+	switch {
+	case failed && l.config.LogLevel >= logger.Error:
+	case slow && l.config.LogLevel >= logger.Warn:
+	case l.config.LogLevel >= logger.Info:
+	default:
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []slog.Attr{
+		slog.String("sql", sql),
+		slog.Duration("duration", elapsed),
+		slog.String("caller", utils.FileWithLineNum()),
+	}
+	if rows >= 0 {
+		attrs = append(attrs, slog.Int64("rows", rows))
+	}
+
+	switch {
+	case failed && l.config.LogLevel >= logger.Error:
+		attrs = append(attrs, slog.String("error", err.Error()))
+		l.logger.LogAttrs(ctx, slog.LevelError, "query failed", attrs...)
+	case slow && l.config.LogLevel >= logger.Warn:
+		attrs = append(attrs, slog.Duration("threshold", l.config.SlowThreshold))
+		attrs = append(attrs, l.explain(ctx)...)
+		l.logger.LogAttrs(ctx, slog.LevelWarn, "slow query", attrs...)
+	default:
+		l.logger.LogAttrs(ctx, slog.LevelInfo, "query", attrs...)
+	}
+}
+
+// explain runs EXPLAIN for the traced statement on a session without logging,
+// statements other than select, insert, update and delete are not explained
+func (l *SlogLogger) explain(ctx context.Context) []slog.Attr {
+	statement, ok := ctx.Value(traceStatementKey{}).(*traceStatement)
+	if !ok || l.db == nil || statement.sql == "" {
+		return nil
+	}
+	verb, _, _ := strings.Cut(strings.TrimSpace(statement.sql), " ")
+	switch strings.ToUpper(verb) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE":
+	default:
+		return nil
+	}
+
+	session := l.db.Session(&gorm.Session{NewDB: true, Logger: logger.Discard, Context: context.WithoutCancel(ctx)})
+	// with PrepareStmt every explained query would stay prepared in the cache
+	if prepared, ok := session.Statement.ConnPool.(*gorm.PreparedStmtDB); ok {
+		session.Statement.ConnPool = prepared.ConnPool
+	}
+	rows, err := session.Raw("EXPLAIN "+statement.sql, statement.vars...).Rows()
+	if err != nil {
+		return []slog.Attr{slog.String("explain_error", err.Error())}
+	}
+	defer rows.Close()
+
+	plan, err := scanTextRows(rows)
+	if err != nil {
+		return []slog.Attr{slog.String("explain_error", err.Error())}
+	}
+	return []slog.Attr{slog.Any("explain", plan)}
+}
+
+// scanTextRows reads every column as text, null columns are left out of the map
+func scanTextRows(rows *sql.Rows) ([]map[string]string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			if values[i].Valid {
+				row[column] = values[i].String
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
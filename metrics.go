@@ -0,0 +1,123 @@
+package belajar_golang_gorm
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// MetricsCollector exports the connection pool statistics of sql.DBStats and
+// the count and latency of statements by table and operation to Prometheus.
+// Install it with db.Use so statements are measured.
+type MetricsCollector struct {
+	db       *gorm.DB
+	queries  *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	maxOpen      *prometheus.Desc
+	open         *prometheus.Desc
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+func NewMetricsCollector() *MetricsCollector {
+	return &MetricsCollector{
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gorm_queries_total",
+			Help: "Number of statements by table, operation and status.",
+		}, []string{"table", "operation", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gorm_query_duration_seconds",
+			Help:    "Latency of statements by table and operation.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"table", "operation"}),
+
+		maxOpen:      prometheus.NewDesc("gorm_db_max_open_connections", "Maximum number of open connections to the database.", nil, nil),
+		open:         prometheus.NewDesc("gorm_db_open_connections", "Number of established connections, in use and idle.", nil, nil),
+		inUse:        prometheus.NewDesc("gorm_db_in_use_connections", "Number of connections currently in use.", nil, nil),
+		idle:         prometheus.NewDesc("gorm_db_idle_connections", "Number of idle connections.", nil, nil),
+		waitCount:    prometheus.NewDesc("gorm_db_wait_count_total", "Number of connections waited for.", nil, nil),
+		waitDuration: prometheus.NewDesc("gorm_db_wait_duration_seconds_total", "Time blocked waiting for a new connection.", nil, nil),
+	}
+}
+
+func (c *MetricsCollector) Name() string {
+	return "prometheus_metrics"
+}
+
+func (c *MetricsCollector) Initialize(db *gorm.DB) error {
+	c.db = db
+	return registerStatementCallbacks(db, "prometheus", c.before, c.after)
+}
+
+func (c *MetricsCollector) before(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		tx.InstanceSet("prometheus:begin", time.Now())
+	}
+}
+
+func (c *MetricsCollector) after(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet("prometheus:begin")
+		if !ok {
+			return
+		}
+
+		status := "ok"
+		if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			status = "error"
+		}
+		c.queries.WithLabelValues(tx.Statement.Table, operation, status).Inc()
+		c.duration.WithLabelValues(tx.Statement.Table, operation).Observe(time.Since(value.(time.Time)).Seconds())
+	}
+}
+
+func (c *MetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.queries.Describe(ch)
+	c.duration.Describe(ch)
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+func (c *MetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.queries.Collect(ch)
+	c.duration.Collect(ch)
+	if c.db == nil {
+		return
+	}
+	sqlDB, err := c.db.DB()
+	if err != nil {
+		return
+	}
+
+	stats := sqlDB.Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+}
+
+// MetricsHandler serves the metrics of db in the Prometheus text format. Only
+// the pool statistics are exported when no MetricsCollector was installed.
+func MetricsHandler(db *gorm.DB) http.Handler {
+	collector, ok := db.Config.Plugins[(&MetricsCollector{}).Name()].(*MetricsCollector)
+	if !ok {
+		collector = NewMetricsCollector()
+		collector.db = db
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
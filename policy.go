@@ -0,0 +1,355 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Actor is the user a statement runs for, an admin passes every policy of the models
+type Actor struct {
+	UserId string
+	Admin  bool
+}
+
+type actorKey struct{}
+
+type skipPolicyKey struct{}
+
+// WithActor runs every statement with the returned context for the actor,
+// ex: db.WithContext(WithActor(ctx, Actor{UserId: "1"})).Take(&wallet)
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// WithoutPolicy lets statements run with the returned context skip every
+// policy, only use it for migrations, health checks and admin tools
+func WithoutPolicy(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipPolicyKey{}, true)
+}
+
+func ActorFromContext(ctx context.Context) (Actor, bool) {
+	if ctx == nil {
+		return Actor{}, false
+	}
+	actor, ok := ctx.Value(actorKey{}).(Actor)
+	return actor, ok
+}
+
+// Policy is implemented by models whose rows are not readable and writable by
+// everyone, like TableName it must have a pointer receiver
+type Policy interface {
+	// ReadScope keeps the rows the actor can read, updates and deletes only reach these rows too
+	ReadScope(actor Actor) func(db *gorm.DB) *gorm.DB
+	// CanWrite reports whether the actor can create, update or delete the row
+	CanWrite(actor Actor) bool
+}
+
+// OwnedByActor is the ReadScope of rows owned by the user in column, admins read every row
+func OwnedByActor(column string, actor Actor) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if actor.Admin {
+			return db
+		}
+		return db.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: actor.UserId})
+	}
+}
+
+// PolicyPlugin applies the Policy of the models from the actor of WithActor,
+// statements on them fail with ErrForbidden without an actor. Reads only see
+// the rows of ReadScope, so the row of another user is not found. Updates and
+// deletes load the rows they reach first and fail with ErrForbidden when the
+// actor can not write one of them, keep their conditions narrow. Updates are
+// checked again with their values assigned, so a row can not be given to
+// another user. Raw sql only requires an actor, it is not filtered. Install it
+// with db.Use.
+type PolicyPlugin struct {
+	// policies by table, used for statements on db.Table without a model
+	policies map[string]Policy
+}
+
+func NewPolicyPlugin() *PolicyPlugin {
+	return &PolicyPlugin{}
+}
+
+func (p *PolicyPlugin) Name() string {
+	return "row_policy"
+}
+
+func (p *PolicyPlugin) Initialize(db *gorm.DB) error {
+	p.policies = make(map[string]Policy)
+	for _, model := range RequiredModels {
+		if policy, ok := model.(Policy); ok {
+			p.policies[tableName(db, model)] = policy
+		}
+	}
+
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Create().Before("gorm:create").Register("policy:create", p.create),
+		callback.Query().Before("gorm:query").Register("policy:query", p.read),
+		callback.Update().Before("gorm:update").Register("policy:update", p.update),
+		callback.Delete().Before("gorm:delete").Register("policy:delete", p.delete),
+		callback.Row().Before("gorm:row").Register("policy:row", p.read),
+		callback.Raw().Before("gorm:raw").Register("policy:raw", p.raw),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// policy returns a new model of the statement when it has a Policy
+func (p *PolicyPlugin) policy(tx *gorm.DB) (Policy, bool) {
+	if tx.Error != nil || tx.Statement.SQL.Len() > 0 || isPolicySkipped(tx.Statement.Context) {
+		return nil, false
+	}
+	if tx.Statement.Schema != nil {
+		policy, ok := reflect.New(tx.Statement.Schema.ModelType).Interface().(Policy)
+		return policy, ok
+	}
+	policy, ok := p.policies[tx.Statement.Table]
+	return policy, ok
+}
+
+func (p *PolicyPlugin) actor(tx *gorm.DB) (Actor, bool) {
+	actor, ok := ActorFromContext(tx.Statement.Context)
+	if !ok {
+		_ = tx.AddError(fmt.Errorf("%w: no actor in context", ErrForbidden))
+	}
+	return actor, ok
+}
+
+func (p *PolicyPlugin) raw(tx *gorm.DB) {
+	if tx.Error == nil && !isPolicySkipped(tx.Statement.Context) {
+		p.actor(tx)
+	}
+}
+
+func (p *PolicyPlugin) read(tx *gorm.DB) {
+	policy, ok := p.policy(tx)
+	if !ok {
+		return
+	}
+	actor, ok := p.actor(tx)
+	if !ok {
+		return
+	}
+	groupConditions(tx)
+	policy.ReadScope(actor)(tx)
+}
+
+func (p *PolicyPlugin) update(tx *gorm.DB) {
+	p.write(tx, true)
+}
+
+func (p *PolicyPlugin) delete(tx *gorm.DB) {
+	p.write(tx, false)
+}
+
+func (p *PolicyPlugin) write(tx *gorm.DB, update bool) {
+	policy, ok := p.policy(tx)
+	if !ok {
+		return
+	}
+	actor, ok := p.actor(tx)
+	if !ok || !checkConditions(tx) {
+		return
+	}
+
+	rows, err := p.storedRows(tx, policy)
+	if err != nil {
+		_ = tx.AddError(err)
+		return
+	}
+	if update {
+		// the actor must be able to write the rows as they are and as they will be
+		updated, err := p.updatedRows(tx, rows)
+		if err != nil {
+			_ = tx.AddError(err)
+			return
+		}
+		rows = append(rows, updated...)
+	}
+	for _, row := range rows {
+		if !row.CanWrite(actor) {
+			_ = tx.AddError(fmt.Errorf("%w: write %s as %s", ErrForbidden, tx.Statement.Table, describeActor(actor)))
+			return
+		}
+	}
+
+	groupConditions(tx)
+	policy.ReadScope(actor)(tx)
+}
+
+// storedRows loads the rows the update or delete reaches, ignoring the policy
+// so the rows of other users are found and forbidden
+func (p *PolicyPlugin) storedRows(tx *gorm.DB, policy Policy) ([]Policy, error) {
+	modelType := reflect.TypeOf(policy).Elem()
+	query := tx.Session(&gorm.Session{NewDB: true, Context: WithoutPolicy(tx.Statement.Context)}).Model(reflect.New(modelType).Interface())
+	if c, ok := tx.Statement.Clauses["WHERE"]; ok {
+		query = query.Clauses(c.Expression)
+	}
+	if tx.Statement.Schema != nil && tx.Statement.ReflectValue.IsValid() {
+		_, values := schema.GetIdentityFieldValuesMap(tx.Statement.Context, tx.Statement.ReflectValue, tx.Statement.Schema.PrimaryFields)
+		if len(values) > 0 {
+			column, values := schema.ToQueryValues(clause.CurrentTable, tx.Statement.Schema.PrimaryFieldDBNames, values)
+			query = query.Where(clause.IN{Column: column, Values: values})
+		}
+	}
+
+	stored := reflect.New(reflect.SliceOf(reflect.PointerTo(modelType)))
+	if err := query.Find(stored.Interface()).Error; err != nil {
+		return nil, err
+	}
+	rows := make([]Policy, stored.Elem().Len())
+	for i := range rows {
+		rows[i] = stored.Elem().Index(i).Interface().(Policy)
+	}
+	return rows, nil
+}
+
+// updatedRows returns copies of the stored rows with the values of the update
+// assigned, from a map of Update or Updates or from the struct of Updates or Save
+func (p *PolicyPlugin) updatedRows(tx *gorm.DB, stored []Policy) ([]Policy, error) {
+	dest := reflect.Indirect(reflect.ValueOf(tx.Statement.Dest))
+	if dest.Kind() != reflect.Map && dest.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	selects, restricted := tx.Statement.SelectAndOmitColumns(false, true)
+
+	rows := make([]Policy, len(stored))
+	for i, row := range stored {
+		updated := reflect.New(reflect.TypeOf(row).Elem())
+		updated.Elem().Set(reflect.ValueOf(row).Elem())
+		if err := p.assign(tx, updated.Elem(), dest, selects, restricted); err != nil {
+			return nil, err
+		}
+		rows[i] = updated.Interface().(Policy)
+	}
+	return rows, nil
+}
+
+// assign sets the values of a map or struct on row like gorm assigns them, sql
+// expressions can not be evaluated so their fields are left zero
+func (p *PolicyPlugin) assign(tx *gorm.DB, row reflect.Value, values reflect.Value, selects map[string]bool, restricted bool) error {
+	statement := &gorm.Statement{DB: tx}
+	if err := statement.Parse(row.Addr().Interface()); err != nil {
+		return err
+	}
+	set := func(field *schema.Field, value interface{}) error {
+		if _, ok := value.(clause.Expression); ok {
+			value = reflect.Zero(field.FieldType).Interface()
+		}
+		return field.Set(tx.Statement.Context, row, value)
+	}
+
+	if values.Kind() == reflect.Map {
+		iter := values.MapRange()
+		for iter.Next() {
+			field := statement.Schema.LookUpField(fmt.Sprint(iter.Key().Interface()))
+			if field == nil || field.DBName == "" {
+				continue
+			}
+			if selected, ok := selects[field.DBName]; (ok && !selected) || (!ok && restricted) {
+				continue
+			}
+			if err := set(field, iter.Value().Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the struct of Updates may be of another model, its columns are matched by name
+	valuesStatement := &gorm.Statement{DB: tx}
+	if err := valuesStatement.Parse(values.Addr().Interface()); err != nil {
+		return err
+	}
+	for _, valuesField := range valuesStatement.Schema.Fields {
+		field := statement.Schema.LookUpField(valuesField.DBName)
+		if valuesField.DBName == "" || field == nil {
+			continue
+		}
+		value, zero := valuesField.ValueOf(tx.Statement.Context, values)
+		if selected, ok := selects[field.DBName]; (ok && selected) || (!ok && !restricted && !zero) {
+			if err := set(field, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *PolicyPlugin) create(tx *gorm.DB) {
+	policy, ok := p.policy(tx)
+	if !ok {
+		return
+	}
+	actor, ok := p.actor(tx)
+	if !ok {
+		return
+	}
+	// the row updated on conflict is not loaded, so only admins can upsert
+	if c, ok := tx.Statement.Clauses["ON CONFLICT"]; ok && !actor.Admin {
+		if onConflict, ok := c.Expression.(clause.OnConflict); ok && (onConflict.UpdateAll || len(onConflict.DoUpdates) > 0) {
+			_ = tx.AddError(fmt.Errorf("%w: upsert on %s", ErrForbidden, tx.Statement.Table))
+			return
+		}
+	}
+
+	// a map is checked as a new row of the model with the values of the map
+	check := func(value reflect.Value) (bool, error) {
+		if value.Kind() == reflect.Map {
+			row := reflect.New(reflect.TypeOf(policy).Elem())
+			if err := p.assign(tx, row.Elem(), value, nil, false); err != nil {
+				return false, err
+			}
+			value = row.Elem()
+		}
+		if value.Kind() != reflect.Struct || !value.CanAddr() {
+			return false, nil
+		}
+		row, ok := value.Addr().Interface().(Policy)
+		return ok && row.CanWrite(actor), nil
+	}
+	allowed := true
+	var err error
+	value := reflect.Indirect(reflect.ValueOf(tx.Statement.Dest))
+	if value.Kind() != reflect.Map && !(value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Map) {
+		value = tx.Statement.ReflectValue
+	}
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len() && allowed && err == nil; i++ {
+			allowed, err = check(reflect.Indirect(value.Index(i)))
+		}
+	default:
+		allowed, err = check(value)
+	}
+	switch {
+	case err != nil:
+		_ = tx.AddError(err)
+	case !allowed:
+		_ = tx.AddError(fmt.Errorf("%w: create %s as %s", ErrForbidden, tx.Statement.Table, describeActor(actor)))
+	}
+}
+
+func isPolicySkipped(ctx context.Context) bool {
+	skipped, _ := ctx.Value(skipPolicyKey{}).(bool)
+	return skipped
+}
+
+func describeActor(actor Actor) string {
+	if actor.Admin {
+		return "admin " + actor.UserId
+	}
+	return "user " + actor.UserId
+}
@@ -0,0 +1,159 @@
+package belajar_golang_gorm
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type PriceService struct {
+	db *gorm.DB
+}
+
+func NewPriceService(db *gorm.DB) *PriceService {
+	return &PriceService{db: db}
+}
+
+// ChangePrice changes the price of the product starting from now
+func (s *PriceService) ChangePrice(productId string, price int64) error {
+	return s.SchedulePrice(productId, price, time.Now())
+}
+
+// SchedulePrice records a price starting from effectiveFrom until the next recorded price.
+// Past or current prices are applied to products.price directly, future prices are
+// applied by ApplyScheduledPrices once they become effective.
+func (s *PriceService) SchedulePrice(productId string, price int64, effectiveFrom time.Time) error {
+	// timestamp columns only keep seconds
+	effectiveFrom = effectiveFrom.Truncate(time.Second)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var product Product
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&product, "id = ?", productId).Error
+		if err != nil {
+			return err
+		}
+
+		err = recordPrice(tx, product, price, effectiveFrom)
+		if err != nil {
+			return err
+		}
+
+		if effectiveFrom.After(time.Now()) {
+			return nil
+		}
+		current, err := priceAt(tx, productId, time.Now())
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Product{}).Where("id = ?", productId).UpdateColumn("price", current).Error
+	})
+}
+
+// PriceAt returns the price of the product at the given time, it falls back to the
+// current price for products without recorded price history
+func (s *PriceService) PriceAt(productId string, at time.Time) (int64, error) {
+	return priceAt(s.db, productId, at)
+}
+
+func (s *PriceService) History(productId string) ([]ProductPrice, error) {
+	var prices []ProductPrice
+	err := s.db.Where("product_id = ?", productId).Order("effective_from asc").Find(&prices).Error
+	return prices, err
+}
+
+// ApplyScheduledPrices copies prices that became effective into products.price,
+// it is meant to be run periodically
+func (s *PriceService) ApplyScheduledPrices() (int64, error) {
+	now := time.Now()
+	current := s.db.Model(&ProductPrice{}).
+		Select("product_prices.price").
+		Where("product_prices.product_id = products.id").
+		Where("product_prices.effective_from <= ?", now).
+		Where("product_prices.effective_to IS NULL OR product_prices.effective_to > ?", now).
+		Limit(1)
+
+	result := s.db.Model(&Product{}).
+		Where("price <> (?)", current).
+		UpdateColumn("price", current)
+	return result.RowsAffected, result.Error
+}
+
+// recordPrice adds price to the history of the product starting from effectiveFrom,
+// product is the product as it was before the change
+func recordPrice(tx *gorm.DB, product Product, price int64, effectiveFrom time.Time) error {
+	var count int64
+	err := tx.Model(&ProductPrice{}).Where("product_id = ?", product.ID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	// keep the price the product had before its first recorded change
+	if count == 0 && effectiveFrom.After(product.CreatedAt) {
+		err = tx.Create(&ProductPrice{
+			ProductId:     product.ID,
+			Price:         product.Price,
+			EffectiveFrom: product.CreatedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var existing ProductPrice
+	err = tx.Take(&existing, "product_id = ? AND effective_from = ?", product.ID, effectiveFrom).Error
+	if err == nil {
+		existing.Price = price
+		return tx.Save(&existing).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return insertPrice(tx, product.ID, price, effectiveFrom)
+}
+
+func insertPrice(tx *gorm.DB, productId string, price int64, effectiveFrom time.Time) error {
+	productPrice := ProductPrice{
+		ProductId:     productId,
+		Price:         price,
+		EffectiveFrom: effectiveFrom,
+	}
+
+	var next ProductPrice
+	err := tx.Where("product_id = ? AND effective_from > ?", productId, effectiveFrom).
+		Order("effective_from asc").
+		Take(&next).Error
+	if err == nil {
+		productPrice.EffectiveTo = &next.EffectiveFrom
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	// close the price that was effective when the new one starts
+	err = tx.Model(&ProductPrice{}).
+		Where("product_id = ? AND effective_from < ?", productId, effectiveFrom).
+		Where("effective_to IS NULL OR effective_to > ?", effectiveFrom).
+		Update("effective_to", effectiveFrom).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&productPrice).Error
+}
+
+func priceAt(db *gorm.DB, productId string, at time.Time) (int64, error) {
+	var productPrice ProductPrice
+	err := db.Where("product_id = ? AND effective_from <= ?", productId, at).
+		Where("effective_to IS NULL OR effective_to > ?", at).
+		Order("effective_from desc").
+		Take(&productPrice).Error
+	if err == nil {
+		return productPrice.Price, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var product Product
+	err = db.Select("id", "price").Take(&product, "id = ?", productId).Error
+	return product.Price, err
+}
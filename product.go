@@ -1,16 +1,101 @@
 package belajar_golang_gorm
 
-import "time"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type Product struct {
-	ID           string    `gorm:"primary_key;column:id" `
-	Name         string    `gorm:"column:name" `
-	Price        int64     `gorm:"column:price" `
-	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime" `
-	UpdatedAt    time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime" `
-	LikedByUsers []User    `gorm:"many2many:user_like_product;foreignKey:id;joinForeignKey:product_id;references:id;joinReferences:user_id"`
+	ID           string         `gorm:"primary_key;column:id" json:"id"`
+	Name         string         `gorm:"column:name" json:"name"`
+	Price        int64          `gorm:"column:price" json:"price"`
+	LikeCount    int64          `gorm:"column:like_count" json:"like_count"`
+	CreatedAt    time.Time      `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time      `gorm:"column:updated_at;autoCreateTime;autoUpdateTime" json:"updated_at"`
+	TenantId     string         `gorm:"column:tenant_id" json:"-"`
+	Prices       []ProductPrice `gorm:"foreignKey:product_id;references:id" json:"prices,omitempty"`
+	LikedByUsers []User         `gorm:"many2many:user_like_product;foreignKey:id;joinForeignKey:product_id;references:id;joinReferences:user_id" json:"liked_by_users,omitempty"`
+
+	// previous are the products reached by the running update before it changes their price
+	previous []Product
 }
 
 func (p *Product) TableName() string {
 	return "products"
 }
+
+// BeforeUpdate loads the products the update reaches when it may change their
+// price, so AfterUpdate can record every price change in the price history,
+// not only the ones made through PriceService
+func (p *Product) BeforeUpdate(db *gorm.DB) error {
+	p.previous = nil
+	if !updatesPrice(db.Statement) {
+		return nil
+	}
+
+	where, ok := db.Statement.Clauses["WHERE"]
+	if !ok && p.ID == "" && !db.AllowGlobalUpdate {
+		// gorm rejects the update without conditions
+		return nil
+	}
+	query := db.Model(&Product{}).Select("id", "price", "created_at")
+	if ok {
+		query = query.Clauses(where.Expression)
+	}
+	if p.ID != "" {
+		query = query.Where("id = ?", p.ID)
+	}
+	return query.Find(&p.previous).Error
+}
+
+func (p *Product) AfterUpdate(db *gorm.DB) error {
+	previous := p.previous
+	p.previous = nil
+	if len(previous) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(previous))
+	for i, product := range previous {
+		ids[i] = product.ID
+	}
+	var updated []Product
+	err := db.Model(&Product{}).Select("id", "price").Where("id IN ?", ids).Find(&updated).Error
+	if err != nil {
+		return err
+	}
+	prices := make(map[string]int64, len(updated))
+	for _, product := range updated {
+		prices[product.ID] = product.Price
+	}
+
+	effectiveFrom := time.Now().Truncate(time.Second)
+	for _, product := range previous {
+		price, ok := prices[product.ID]
+		if !ok || price == product.Price {
+			continue
+		}
+		err = recordPrice(db, product, price, effectiveFrom)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatesPrice reports whether the update statement can assign the price column
+func updatesPrice(stmt *gorm.Statement) bool {
+	selects, restricted := stmt.SelectAndOmitColumns(false, true)
+	if selected, ok := selects["price"]; ok {
+		return selected
+	}
+	if restricted {
+		return false
+	}
+	if values, ok := stmt.Dest.(map[string]interface{}); ok {
+		_, column := values["price"]
+		_, field := values["Price"]
+		return column || field
+	}
+	return true
+}
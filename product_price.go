@@ -0,0 +1,25 @@
+package belajar_golang_gorm
+
+import "time"
+
+// ProductPrice is a price of a product valid from EffectiveFrom until EffectiveTo,
+// EffectiveTo is nil for the latest price
+type ProductPrice struct {
+	ID            int64      `gorm:"primary_key;column:id;autoIncrement"`
+	ProductId     string     `gorm:"column:product_id"`
+	Price         int64      `gorm:"column:price"`
+	EffectiveFrom time.Time  `gorm:"column:effective_from"`
+	EffectiveTo   *time.Time `gorm:"column:effective_to"`
+	CreatedAt     time.Time  `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+	TenantId      string     `gorm:"column:tenant_id"`
+	Product       *Product   `gorm:"foreignKey:product_id;references:id"`
+}
+
+func (pp *ProductPrice) TableName() string {
+	return "product_prices"
+}
+
+func (pp *ProductPrice) IsEffectiveAt(at time.Time) bool {
+	return !pp.EffectiveFrom.After(at) && (pp.EffectiveTo == nil || pp.EffectiveTo.After(at))
+}
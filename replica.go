@@ -0,0 +1,127 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+type forcePrimaryKey struct{}
+
+// ForcePrimary sends every statement run with the returned context to the
+// primary, use it to read rows right after writing them
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+func isPrimaryForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(forcePrimaryKey{}).(bool)
+	return forced
+}
+
+// ReplicaResolver sends reads to the replicas in turn and everything else to
+// the primary. Statements stay on the primary inside db.Transaction and
+// db.Connection, with clause.Locking, when the context is from ForcePrimary and
+// for raw sql that is not a select. Install it on the primary with db.Use.
+type ReplicaResolver struct {
+	replicas []*gorm.DB
+	next     atomic.Uint64
+}
+
+func NewReplicaResolver(replicas ...*gorm.DB) *ReplicaResolver {
+	return &ReplicaResolver{replicas: replicas}
+}
+
+// OpenReplicatedDatabase opens the primary and the replicas with OpenDatabase,
+// without replicas it is the same as OpenDatabase
+func OpenReplicatedDatabase(primary string, replicas ...string) (*gorm.DB, error) {
+	db, err := OpenDatabase(primary)
+	if err != nil || len(replicas) == 0 {
+		return db, err
+	}
+
+	replicaDBs := make([]*gorm.DB, len(replicas))
+	for i, dsn := range replicas {
+		replicaDBs[i], err = OpenDatabase(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = db.Use(NewReplicaResolver(replicaDBs...))
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// ReplicasOf returns the replicas of the ReplicaResolver installed on db by
+// name, replica-1, replica-2 and so on, it is nil without replicas
+func ReplicasOf(db *gorm.DB) map[string]*gorm.DB {
+	resolver, ok := db.Config.Plugins[(&ReplicaResolver{}).Name()].(*ReplicaResolver)
+	if !ok || len(resolver.replicas) == 0 {
+		return nil
+	}
+	replicas := make(map[string]*gorm.DB, len(resolver.replicas))
+	for i, replica := range resolver.replicas {
+		replicas["replica-"+strconv.Itoa(i+1)] = replica
+	}
+	return replicas
+}
+
+func (r *ReplicaResolver) Name() string {
+	return "replica_resolver"
+}
+
+func (r *ReplicaResolver) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Query().Before("gorm:query").Register("replica:before_query", r.route),
+		callback.Query().After("gorm:query").Register("replica:after_query", r.restore),
+		callback.Row().Before("gorm:row").Register("replica:before_row", r.route),
+		callback.Row().After("gorm:row").Register("replica:after_row", r.restore),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ReplicaResolver) route(tx *gorm.DB) {
+	if len(r.replicas) == 0 || !r.readOnly(tx) {
+		return
+	}
+	replica := r.replicas[(r.next.Add(1)-1)%uint64(len(r.replicas))]
+	tx.InstanceSet("replica:primary", tx.Statement.ConnPool)
+	tx.Statement.ConnPool = replica.Config.ConnPool
+}
+
+// restore puts the primary back so a chain reused for a write does not keep the replica
+func (r *ReplicaResolver) restore(tx *gorm.DB) {
+	if primary, ok := tx.InstanceGet("replica:primary"); ok {
+		tx.Statement.ConnPool = primary.(gorm.ConnPool)
+	}
+}
+
+func (r *ReplicaResolver) readOnly(tx *gorm.DB) bool {
+	// a transaction or a connection replaces the pool of the statement
+	if tx.Statement.ConnPool != tx.Config.ConnPool {
+		return false
+	}
+	if _, locking := tx.Statement.Clauses[clause.Locking{}.Name()]; locking {
+		return false
+	}
+	if isPrimaryForced(tx.Statement.Context) {
+		return false
+	}
+	if sql := strings.TrimSpace(tx.Statement.SQL.String()); sql != "" {
+		verb, _, _ := strings.Cut(sql, " ")
+		upper := strings.ToUpper(sql)
+		return strings.EqualFold(verb, "SELECT") && !strings.Contains(upper, " FOR UPDATE") &&
+			!strings.Contains(upper, " FOR SHARE") && !strings.Contains(upper, " LOCK IN SHARE MODE")
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package belajar_golang_gorm
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	DefaultPageSize = 10
+	MaxPageSize     = 100
+)
+
+// BalanceBetween keeps rows with min <= balance <= max
+func BalanceBetween(min int64, max int64) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.And(
+			clause.Gte{Column: clause.Column{Table: clause.CurrentTable, Name: "balance"}, Value: min},
+			clause.Lte{Column: clause.Column{Table: clause.CurrentTable, Name: "balance"}, Value: max},
+		))
+	}
+}
+
+// CreatedBetween keeps rows with from <= created_at < to, a zero from or to leaves that side open
+func CreatedBetween(from time.Time, to time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		column := clause.Column{Table: clause.CurrentTable, Name: "created_at"}
+		if !from.IsZero() {
+			db = db.Where(clause.Gte{Column: column, Value: from})
+		}
+		if !to.IsZero() {
+			db = db.Where(clause.Lt{Column: column, Value: to})
+		}
+		return db
+	}
+}
+
+func OwnedBy(userId string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "user_id"}, Value: userId})
+	}
+}
+
+// Search keeps rows where any of the columns contains term, an empty term keeps
+// all rows. Encrypted columns fail the query with ErrEncryptedColumn.
+func Search(columns []string, term string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if term == "" || len(columns) == 0 {
+			return db
+		}
+
+		pattern := "%" + escapeLike(term) + "%"
+		searched := make([]clause.Column, len(columns))
+		conditions := make([]clause.Expression, len(columns))
+		for i, column := range columns {
+			searched[i] = clause.Column{Table: clause.CurrentTable, Name: column}
+			conditions[i] = clause.Like{Column: searched[i], Value: pattern}
+		}
+		if rejectEncrypted(db, searched) {
+			return db
+		}
+		return db.Where(clause.And(clause.Or(conditions...)))
+	}
+}
+
+// Paginate limits the query to a page starting at 1, size is clamped to MaxPageSize
+// and defaults to DefaultPageSize
+func Paginate(page int, size int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if page < 1 {
+			page = 1
+		}
+		if size < 1 {
+			size = DefaultPageSize
+		}
+		if size > MaxPageSize {
+			size = MaxPageSize
+		}
+		return db.Limit(size).Offset((page - 1) * size)
+	}
+}
@@ -0,0 +1,203 @@
+package belajar_golang_gorm
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+)
+
+var ErrFullTextUnsupported = errors.New("full text search is not supported by this database")
+
+// FullTextIndex describes the columns of a table searched together
+type FullTextIndex struct {
+	Name    string
+	Table   string
+	Columns []string
+}
+
+var (
+	ProductFullTextIndex   = FullTextIndex{Name: "products_name_fulltext", Table: "products", Columns: []string{"name"}}
+	TodoFullTextIndex      = FullTextIndex{Name: "todos_title_description_fulltext", Table: "todos", Columns: []string{"title", "description"}}
+	GuestBookFullTextIndex = FullTextIndex{Name: "guest_book_message_fulltext", Table: "guest_book", Columns: []string{"message"}}
+)
+
+type SearchResult[T any] struct {
+	Item  T
+	Score float64
+}
+
+// FullTextSearch ranks rows of T by relevance using the full text index of the
+// database: FULLTEXT on mysql, a tsvector gin index on postgres and an FTS5
+// table named {table}_fts on sqlite
+type FullTextSearch[T any] struct {
+	db    *gorm.DB
+	index FullTextIndex
+}
+
+func NewProductSearch(db *gorm.DB) *FullTextSearch[Product] {
+	return &FullTextSearch[Product]{db: db, index: ProductFullTextIndex}
+}
+
+func NewTodoSearch(db *gorm.DB) *FullTextSearch[Todo] {
+	return &FullTextSearch[Todo]{db: db, index: TodoFullTextIndex}
+}
+
+// NewGuestBookSearch searches every message, pass a db scoped to approved
+// messages when the results are shown to visitors
+func NewGuestBookSearch(db *gorm.DB) *FullTextSearch[GuestBook] {
+	return &FullTextSearch[GuestBook]{db: db, index: GuestBookFullTextIndex}
+}
+
+// MigrateFullText creates the full text indexes of products, todos and guest
+// book, user names are encrypted and have none
+func MigrateFullText(db *gorm.DB) error {
+	for _, index := range []FullTextIndex{ProductFullTextIndex, TodoFullTextIndex, GuestBookFullTextIndex} {
+		err := CreateFullTextIndex(db, index)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateFullTextIndex creates the index when it does not exist yet
+func CreateFullTextIndex(db *gorm.DB, index FullTextIndex) error {
+	columns := strings.Join(index.Columns, ", ")
+	switch db.Dialector.Name() {
+	case "mysql":
+		if db.Migrator().HasIndex(index.Table, index.Name) {
+			return nil
+		}
+		return db.Exec(fmt.Sprintf("ALTER TABLE %s ADD FULLTEXT INDEX %s (%s)", index.Table, index.Name, columns)).Error
+	case "postgres":
+		return db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING gin ((%s))",
+			index.Name, index.Table, tsvector(index.Columns))).Error
+	case "sqlite":
+		// an external content table only stores the index, triggers keep it in sync with the table
+		fts := index.Table + "_fts"
+		newColumns := "new." + strings.Join(index.Columns, ", new.")
+		oldColumns := "old." + strings.Join(index.Columns, ", old.")
+		return db.Transaction(func(tx *gorm.DB) error {
+			statements := []string{
+				fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(%s, content='%s', content_rowid='rowid')", fts, columns, index.Table),
+				fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s_ai AFTER INSERT ON %s BEGIN "+
+					"INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s); END", fts, index.Table, fts, columns, newColumns),
+				fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s_ad AFTER DELETE ON %s BEGIN "+
+					"INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s); END", fts, index.Table, fts, fts, columns, oldColumns),
+				fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %s_au AFTER UPDATE ON %s BEGIN "+
+					"INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.rowid, %s); "+
+					"INSERT INTO %s(rowid, %s) VALUES (new.rowid, %s); END", fts, index.Table, fts, fts, columns, oldColumns, fts, columns, newColumns),
+				fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", fts, fts),
+			}
+			for _, statement := range statements {
+				err := tx.Exec(statement).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return ErrFullTextUnsupported
+}
+
+// Search returns at most limit rows matching the query, most relevant first.
+// Higher scores are more relevant, scores are only comparable within one database.
+func (s *FullTextSearch[T]) Search(query string, limit int) ([]SearchResult[T], error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+
+	score, match, err := s.match(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// ids are ranked first and the rows loaded afterwards, so T is queried
+	// like any other model with its hooks and soft delete
+	db := s.db.Session(&gorm.Session{})
+	statement := &gorm.Statement{DB: db}
+	err = statement.Parse(new(T))
+	if err != nil {
+		return nil, err
+	}
+	primaryKey := clause.Column{Table: clause.CurrentTable, Name: statement.Schema.PrioritizedPrimaryField.DBName}
+
+	var scores []struct {
+		ID    string
+		Score float64
+	}
+	err = db.Model(new(T)).
+		Select("? AS id, ? AS score", primaryKey, score).
+		Where(match).
+		Order("score desc").
+		Limit(limit).
+		Scan(&scores).Error
+	if err != nil || len(scores) == 0 {
+		return nil, err
+	}
+
+	ids := make([]interface{}, len(scores))
+	for i, score := range scores {
+		ids[i] = score.ID
+	}
+	var items []T
+	err = db.Where(clause.IN{Column: primaryKey, Values: ids}).Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]T, len(items))
+	for i := range items {
+		id, _ := statement.Schema.PrioritizedPrimaryField.ValueOf(db.Statement.Context, reflect.ValueOf(&items[i]).Elem())
+		byId[fmt.Sprint(id)] = items[i]
+	}
+	results := make([]SearchResult[T], 0, len(scores))
+	for _, score := range scores {
+		if item, ok := byId[score.ID]; ok {
+			results = append(results, SearchResult[T]{Item: item, Score: score.Score})
+		}
+	}
+	return results, nil
+}
+
+func (s *FullTextSearch[T]) match(query string) (clause.Expr, clause.Expr, error) {
+	columns := strings.Join(s.index.Columns, ", ")
+	switch s.db.Dialector.Name() {
+	case "mysql":
+		sql := "MATCH(" + columns + ") AGAINST (? IN NATURAL LANGUAGE MODE)"
+		return clause.Expr{SQL: sql, Vars: []interface{}{query}}, clause.Expr{SQL: sql, Vars: []interface{}{query}}, nil
+	case "postgres":
+		document := tsvector(s.index.Columns)
+		return clause.Expr{SQL: "ts_rank(" + document + ", plainto_tsquery('simple', ?))", Vars: []interface{}{query}},
+			clause.Expr{SQL: document + " @@ plainto_tsquery('simple', ?)", Vars: []interface{}{query}}, nil
+	case "sqlite":
+		// bm25 is lower for better matches so it is negated to rank like the other databases
+		fts := s.index.Table + "_fts"
+		terms := ftsQuery(query)
+		return clause.Expr{SQL: "-(SELECT bm25(" + fts + ") FROM " + fts + " WHERE " + fts + " MATCH ? AND " +
+				fts + ".rowid = " + s.index.Table + ".rowid)", Vars: []interface{}{terms}},
+			clause.Expr{SQL: s.index.Table + ".rowid IN (SELECT rowid FROM " + fts + " WHERE " + fts + " MATCH ?)", Vars: []interface{}{terms}}, nil
+	}
+	return clause.Expr{}, clause.Expr{}, ErrFullTextUnsupported
+}
+
+func tsvector(columns []string) string {
+	return "to_tsvector('simple', coalesce(" + strings.Join(columns, ", '') || ' ' || coalesce(") + ", ''))"
+}
+
+// ftsQuery quotes every word so FTS5 operators in the query are searched as text,
+// words are joined with OR like the natural language mode of mysql
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " OR ")
+}
@@ -0,0 +1,34 @@
+package belajar_golang_gorm
+
+import "fmt"
+
+const redacted = "[REDACTED]"
+
+// Secret is a string that is stored as is but never printed, it is redacted in
+// fmt verbs, json and gorm sql logs
+type Secret string
+
+func (s Secret) String() string {
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return `"` + redacted + `"`
+}
+
+func (s Secret) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(redacted))
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(redacted), nil
+}
+
+// Reveal returns the real value, only use it where the secret is really needed
+func (s Secret) Reveal() string {
+	return string(s)
+}
@@ -0,0 +1,386 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrCrossShardTransaction = errors.New("transaction spans more than one shard")
+var ErrWrongShard = errors.New("row belongs to another shard")
+
+// shardKeys are the columns holding the user id of the sharded tables, the
+// other tables are not sharded and live on the first shard
+var shardKeys = map[string]string{
+	"users":     "id",
+	"user_logs": "user_id",
+	"wallets":   "user_id",
+	"addresses": "user_id",
+	"todos":     "user_id",
+}
+
+// ShardedDB spreads users, user logs, wallets, addresses and todos over N
+// databases by the hash of the user id, so every row of a user lives on the
+// same shard. The number of shards can not change without moving rows.
+type ShardedDB struct {
+	shards []*gorm.DB
+}
+
+// NewShardedDB installs a ShardGuard on every shard so rows created on the
+// wrong shard are rejected
+func NewShardedDB(shards ...*gorm.DB) (*ShardedDB, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("at least one shard is required")
+	}
+	for i, shard := range shards {
+		err := shard.Use(&ShardGuard{index: i, count: len(shards)})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ShardedDB{shards: shards}, nil
+}
+
+// OpenShardedDatabase opens every shard with OpenDatabase, the order of the
+// dsn decides which users a shard holds and must never change
+func OpenShardedDatabase(dsns ...string) (*ShardedDB, error) {
+	shards := make([]*gorm.DB, len(dsns))
+	for i, dsn := range dsns {
+		var err error
+		shards[i], err = OpenDatabase(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewShardedDB(shards...)
+}
+
+// ShardIndex returns the shard of the user among count shards
+func ShardIndex(userId string, count int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(userId))
+	return int(hash.Sum32() % uint32(count))
+}
+
+func (s *ShardedDB) Shards() []*gorm.DB {
+	return s.shards
+}
+
+// Default is the shard of the tables that are not sharded, like products
+func (s *ShardedDB) Default() *gorm.DB {
+	return s.shards[0]
+}
+
+// For returns the shard holding the rows of the user
+func (s *ShardedDB) For(userId string) *gorm.DB {
+	return s.shards[ShardIndex(userId, len(s.shards))]
+}
+
+// Transaction runs fn on the shard of the users, ErrCrossShardTransaction is
+// returned without starting a transaction when the users are on different shards
+func (s *ShardedDB) Transaction(userIds []string, fn func(tx *gorm.DB) error) error {
+	if len(userIds) == 0 {
+		return fmt.Errorf("%w: no user given", ErrCrossShardTransaction)
+	}
+	index := ShardIndex(userIds[0], len(s.shards))
+	for _, userId := range userIds[1:] {
+		if other := ShardIndex(userId, len(s.shards)); other != index {
+			return fmt.Errorf("%w: user %q is on shard %d and user %q on shard %d",
+				ErrCrossShardTransaction, userIds[0], index, userId, other)
+		}
+	}
+	return s.shards[index].Transaction(fn)
+}
+
+// ShardGuard rejects writing a row of a sharded table whose user id hashes to
+// another shard, e.g. a wallet of another user inside ShardedDB.Transaction.
+// Creates are checked by their rows. Updates, saves and deletes are checked by
+// the user id of their model, of the assigned values and of conditions built
+// from structs, maps or clause.Eq and clause.IN. Conditions written as sql
+// strings are not inspected, routing them to the right shard is up to the caller.
+type ShardGuard struct {
+	index int
+	count int
+}
+
+func (g *ShardGuard) Name() string {
+	return "shard_guard"
+}
+
+func (g *ShardGuard) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Create().Before("gorm:create").Register("shard:guard_create", g.guardCreate),
+		callback.Update().Before("gorm:update").Register("shard:guard_update", g.guardWrite),
+		callback.Delete().Before("gorm:delete").Register("shard:guard_delete", g.guardWrite),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shardKey returns the field holding the user id of the statement's table
+func (g *ShardGuard) shardKey(tx *gorm.DB) *schema.Field {
+	if tx.Error != nil || tx.Statement.Schema == nil {
+		return nil
+	}
+	key, ok := shardKeys[tx.Statement.Schema.Table]
+	if !ok {
+		return nil
+	}
+	return tx.Statement.Schema.LookUpField(key)
+}
+
+func (g *ShardGuard) check(tx *gorm.DB, userId interface{}) {
+	id := fmt.Sprint(userId)
+	if index := ShardIndex(id, g.count); index != g.index {
+		_ = tx.AddError(fmt.Errorf("%w: user %q is on shard %d, not %d", ErrWrongShard, id, index, g.index))
+	}
+}
+
+func (g *ShardGuard) guardCreate(tx *gorm.DB) {
+	field := g.shardKey(tx)
+	if field == nil {
+		return
+	}
+	g.eachRow(tx.Statement.ReflectValue, func(row reflect.Value) {
+		userId, _ := field.ValueOf(tx.Statement.Context, row)
+		g.check(tx, userId)
+	})
+}
+
+func (g *ShardGuard) guardWrite(tx *gorm.DB) {
+	field := g.shardKey(tx)
+	if field == nil {
+		return
+	}
+	var userIds []interface{}
+	collect := func(row reflect.Value) {
+		if row.Kind() == reflect.Struct && row.Type() == tx.Statement.Schema.ModelType {
+			if userId, zero := field.ValueOf(tx.Statement.Context, row); !zero {
+				userIds = append(userIds, userId)
+			}
+		}
+	}
+	g.eachRow(tx.Statement.ReflectValue, collect)
+
+	switch dest := reflect.Indirect(reflect.ValueOf(tx.Statement.Dest)); dest.Kind() {
+	case reflect.Map:
+		for _, name := range []string{field.DBName, field.Name} {
+			if value := dest.MapIndex(reflect.ValueOf(name)); value.IsValid() && dest.Type().Key().Kind() == reflect.String {
+				userIds = append(userIds, value.Interface())
+			}
+		}
+	case reflect.Struct:
+		collect(dest)
+	}
+
+	if c, ok := tx.Statement.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok {
+			userIds = append(userIds, shardKeyConditions(where.Exprs, field.DBName)...)
+		}
+	}
+	for _, userId := range userIds {
+		if _, ok := userId.(clause.Expression); !ok {
+			g.check(tx, userId)
+		}
+	}
+}
+
+func (g *ShardGuard) eachRow(value reflect.Value, fn func(row reflect.Value)) {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			fn(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		fn(value)
+	}
+}
+
+// shardKeyConditions returns the values the conditions compare the column to
+// with = or IN, conditions inside an OR or a NOT are skipped
+func shardKeyConditions(exprs []clause.Expression, column string) []interface{} {
+	isColumn := func(value interface{}) bool {
+		switch c := value.(type) {
+		case clause.Column:
+			return c.Name == column
+		case string:
+			return c == column
+		}
+		return false
+	}
+	var values []interface{}
+	for _, expr := range exprs {
+		switch e := expr.(type) {
+		case clause.Eq:
+			if isColumn(e.Column) {
+				values = append(values, e.Value)
+			}
+		case clause.IN:
+			if isColumn(e.Column) {
+				values = append(values, e.Values...)
+			}
+		case clause.AndConditions:
+			values = append(values, shardKeyConditions(e.Exprs, column)...)
+		}
+	}
+	return values
+}
+
+// FanOut runs the query on every shard at once and merges the rows by order,
+// which uses the syntax of NewKeyset with columns of T. Every shard returns up
+// to offset+limit rows so deep pages get slower, prefer narrow admin filters.
+// Strings are merged like the utf8mb4_0900_ai_ci collation of mysql, ignoring
+// case and accents, so columns with a binary collation may merge differently.
+func FanOut[T any](ctx context.Context, s *ShardedDB, order string, offset int, limit int, scopes ...func(db *gorm.DB) *gorm.DB) ([]T, error) {
+	keyset, err := NewKeyset(order)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	fields, err := keyset.fields(s.shards[0].Model(new(T)))
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]clause.OrderByColumn, len(fields))
+	for i, field := range fields {
+		if field.relation != nil {
+			return nil, fmt.Errorf("%w: fan out can not order by %q", ErrInvalidKeysetOrder, field.relation.Name)
+		}
+		orders[i] = clause.OrderByColumn{Column: field.column, Desc: keyset.columns[i].desc}
+	}
+
+	results := make([][]T, len(s.shards))
+	errs := make([]error, len(s.shards))
+	var wait sync.WaitGroup
+	for i, shard := range s.shards {
+		wait.Add(1)
+		go func(i int, shard *gorm.DB) {
+			defer wait.Done()
+			errs[i] = shard.WithContext(ctx).
+				Scopes(scopes...).
+				Clauses(clause.OrderBy{Columns: orders}).
+				Limit(offset + limit).
+				Find(&results[i]).Error
+		}(i, shard)
+	}
+	wait.Wait()
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	var merged []T
+	for _, rows := range results {
+		merged = append(merged, rows...)
+	}
+	collator := newCollator()
+	sort.SliceStable(merged, func(a, b int) bool {
+		valueA, valueB := reflect.ValueOf(&merged[a]).Elem(), reflect.ValueOf(&merged[b]).Elem()
+		for i, field := range fields {
+			x, _ := field.field.ValueOf(ctx, valueA)
+			y, _ := field.field.ValueOf(ctx, valueB)
+			if c := compareValues(collator, exportValue(x), exportValue(y)); c != 0 {
+				return (c < 0) != keyset.columns[i].desc
+			}
+		}
+		return false
+	})
+
+	if offset >= len(merged) {
+		return []T{}, nil
+	}
+	merged = merged[offset:]
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged, nil
+}
+
+// FanOutCount adds the counts of the query on every shard
+func FanOutCount[T any](ctx context.Context, s *ShardedDB, scopes ...func(db *gorm.DB) *gorm.DB) (int64, error) {
+	var total int64
+	for _, shard := range s.shards {
+		var count int64
+		err := shard.WithContext(ctx).Model(new(T)).Scopes(scopes...).Count(&count).Error
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// newCollator compares strings like the default utf8mb4_0900_ai_ci collation,
+// a collator is not safe for concurrent use
+func newCollator() *collate.Collator {
+	return collate.New(language.Und, collate.IgnoreCase, collate.IgnoreDiacritics, collate.IgnoreWidth)
+}
+
+// compareValues orders the values returned by exportValue, null is first like in mysql
+func compareValues(collator *collate.Collator, a interface{}, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			return compareOrdered(a, b)
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return compareOrdered(a, b)
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return collator.CompareString(a, b)
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b)
+		}
+	case bool:
+		if b, ok := b.(bool); ok && a != b {
+			if a {
+				return 1
+			}
+			return -1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareOrdered[T int64 | float64](a T, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+package belajar_golang_gorm
+
+import (
+	"regexp"
+	"strings"
+)
+
+type SpamClassifier interface {
+	IsSpam(guestBook *GuestBook) (bool, error)
+}
+
+var linkPattern = regexp.MustCompile(`(?i)https?://|www\.`)
+
+// KeywordSpamClassifier marks messages containing one of the keywords or
+// more than MaxLinks links as spam
+type KeywordSpamClassifier struct {
+	Keywords []string
+	MaxLinks int
+}
+
+func NewKeywordSpamClassifier(keywords ...string) *KeywordSpamClassifier {
+	return &KeywordSpamClassifier{Keywords: keywords, MaxLinks: 2}
+}
+
+func (c *KeywordSpamClassifier) IsSpam(guestBook *GuestBook) (bool, error) {
+	text := strings.ToLower(guestBook.Name + " " + guestBook.Message)
+	for _, keyword := range c.Keywords {
+		if keyword != "" && strings.Contains(text, strings.ToLower(keyword)) {
+			return true, nil
+		}
+	}
+
+	links := len(linkPattern.FindAllStringIndex(guestBook.Message, -1))
+	return links > c.MaxLinks, nil
+}
@@ -0,0 +1,116 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"database/sql"
+	"gorm.io/gorm"
+)
+
+const DefaultBatchSize = 500
+
+// Iterator scans the rows of a query into T one at a time so large results are
+// never loaded into memory at once. The rows are closed when Next returns false,
+// call Close when stopping early. AfterFind hooks are not called.
+type Iterator[T any] struct {
+	ctx    context.Context
+	query  *gorm.DB
+	rows   *sql.Rows
+	item   T
+	err    error
+	closed bool
+}
+
+// Stream prepares an iterator over the query, the query runs on the first call to Next
+func Stream[T any](ctx context.Context, query *gorm.DB) *Iterator[T] {
+	return &Iterator[T]{ctx: ctx, query: query}
+}
+
+func (it *Iterator[T]) Next() bool {
+	if it.closed {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		_ = it.Close()
+		return false
+	}
+
+	if it.rows == nil {
+		it.query = it.query.WithContext(it.ctx)
+		if it.query.Statement.Model == nil {
+			it.query = it.query.Model(new(T))
+		}
+		it.rows, it.err = it.query.Rows()
+		if it.err != nil {
+			it.closed = true
+			return false
+		}
+	}
+
+	if !it.rows.Next() {
+		it.err = it.rows.Err()
+		_ = it.Close()
+		return false
+	}
+
+	var item T
+	if err := it.query.ScanRows(it.rows, &item); err != nil {
+		it.err = err
+		_ = it.Close()
+		return false
+	}
+	it.item = item
+	return true
+}
+
+// Value returns the row scanned by the last call to Next
+func (it *Iterator[T]) Value() T {
+	return it.item
+}
+
+// Err returns the error that stopped the iteration, including context cancellation
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Close releases the rows, it is safe to call more than once
+func (it *Iterator[T]) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	if it.rows == nil {
+		return nil
+	}
+	return it.rows.Close()
+}
+
+// StreamEach calls fn for every row, an error returned by fn stops the iteration
+func StreamEach[T any](ctx context.Context, query *gorm.DB, fn func(item T) error) error {
+	it := Stream[T](ctx, query)
+	defer it.Close()
+
+	for it.Next() {
+		if err := fn(it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// StreamBatches calls fn with chunks of at most batchSize rows using FindInBatches,
+// every chunk is a separate query ordered by primary key so no connection is held
+// between chunks. The batch slice is reused, copy it to keep rows after fn returns.
+func StreamBatches[T any](ctx context.Context, query *gorm.DB, batchSize int, fn func(batch []T) error) error {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	var batch []T
+	return query.WithContext(ctx).FindInBatches(&batch, batchSize, func(tx *gorm.DB, number int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(batch)
+	}).Error
+}
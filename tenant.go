@@ -0,0 +1,311 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// TenantColumn holds the organization owning a row in every table
+const TenantColumn = "tenant_id"
+
+var ErrMissingTenant = errors.New("no tenant in context")
+var ErrTenantMismatch = errors.New("row belongs to another tenant")
+var ErrTenantUpsert = errors.New("upsert could update a row of another tenant")
+var ErrTenantJoin = errors.New("join can not be scoped to the tenant")
+
+// joinedTablePattern finds the tables joined by a join written in sql
+var joinedTablePattern = regexp.MustCompile("(?i)\\bjoin\\s+`?(\\w+)`?")
+
+type tenantKey struct{}
+
+type withoutTenantKey struct{}
+
+// WithTenant scopes every statement run with the returned context to the tenant,
+// ex: db.WithContext(WithTenant(ctx, "acme")).Find(&users)
+func WithTenant(ctx context.Context, tenantId string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantId)
+}
+
+// TenantFromContext returns the tenant of WithTenant, it is empty when there is none
+func TenantFromContext(ctx context.Context) string {
+	if ctx != nil {
+		if tenantId, ok := ctx.Value(tenantKey{}).(string); ok {
+			return tenantId
+		}
+	}
+	return ""
+}
+
+// WithoutTenant lets statements run with the returned context see the rows of
+// every tenant, only use it for migrations, health checks and admin tools
+func WithoutTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutTenantKey{}, true)
+}
+
+func isTenantSkipped(ctx context.Context) bool {
+	skipped, _ := ctx.Value(withoutTenantKey{}).(bool)
+	return skipped
+}
+
+// TenantPlugin fails every statement with ErrMissingTenant unless its context
+// is from WithTenant or WithoutTenant. Queries, updates and deletes of models
+// with a tenant_id column get WHERE tenant_id = ? and creates set the column.
+// Relationships loaded with Joins get the tenant in their ON. A join written in
+// sql can not be filtered, joining a table with a tenant_id column that way
+// fails with ErrTenantJoin, join a subquery of the model instead, e.g.
+// Joins("join (?) user_like_product on ...", db.Model(&UserLikeProduct{})).
+// Raw sql is not filtered, it must filter tenant_id itself. OpenDatabase
+// installs it, other connections install it with db.Use.
+type TenantPlugin struct {
+	// tables with a tenant_id column, used for statements on db.Table without a model
+	tables map[string]bool
+}
+
+func NewTenantPlugin() *TenantPlugin {
+	return &TenantPlugin{}
+}
+
+func (p *TenantPlugin) Name() string {
+	return "tenant"
+}
+
+func (p *TenantPlugin) Initialize(db *gorm.DB) error {
+	p.tables = make(map[string]bool, len(RequiredModels))
+	for _, model := range RequiredModels {
+		statement := &gorm.Statement{DB: db}
+		if err := statement.Parse(model); err != nil {
+			return err
+		}
+		if statement.Schema.LookUpField(TenantColumn) != nil {
+			p.tables[statement.Table] = true
+		}
+	}
+
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Create().Before("gorm:create").Register("tenant:create", p.create),
+		callback.Query().Before("gorm:query").Register("tenant:query", p.filter),
+		callback.Update().Before("gorm:update").Register("tenant:update", p.update),
+		callback.Delete().Before("gorm:delete").Register("tenant:delete", p.delete),
+		callback.Row().Before("gorm:row").Register("tenant:row", p.filter),
+		callback.Raw().Before("gorm:raw").Register("tenant:raw", p.raw),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tenant returns the tenant of the statement, ok is false when the tenant is
+// skipped or the statement failed because there is none
+func (p *TenantPlugin) tenant(tx *gorm.DB) (string, bool) {
+	if tx.Error != nil || isTenantSkipped(tx.Statement.Context) {
+		return "", false
+	}
+	tenantId := TenantFromContext(tx.Statement.Context)
+	if tenantId == "" {
+		_ = tx.AddError(ErrMissingTenant)
+		return "", false
+	}
+	return tenantId, true
+}
+
+// raw can not be filtered, it only fails closed without a tenant
+func (p *TenantPlugin) raw(tx *gorm.DB) {
+	p.tenant(tx)
+}
+
+func (p *TenantPlugin) hasTenantColumn(tx *gorm.DB) bool {
+	if tx.Statement.Schema != nil {
+		return tx.Statement.Schema.LookUpField(TenantColumn) != nil
+	}
+	return p.tables[tx.Statement.Table]
+}
+
+func (p *TenantPlugin) filter(tx *gorm.DB) {
+	tenantId, ok := p.tenant(tx)
+	if !ok || tx.Statement.SQL.Len() > 0 {
+		return
+	}
+	if !p.filterJoins(tx, tenantId) || !p.hasTenantColumn(tx) {
+		return
+	}
+
+	groupConditions(tx)
+	tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: TenantColumn}, Value: tenantId},
+	}})
+}
+
+// filterJoins adds the tenant to the ON of the joined relationships with a
+// tenant_id column and rejects joins written in sql of tables with one
+func (p *TenantPlugin) filterJoins(tx *gorm.DB, tenantId string) bool {
+	for i, join := range tx.Statement.Joins {
+		relations := joinRelations(tx.Statement.Schema, join.Name)
+		if relations == nil {
+			for _, match := range joinedTablePattern.FindAllStringSubmatch(join.Name, -1) {
+				if p.tables[match[1]] {
+					_ = tx.AddError(fmt.Errorf("%w: %s is joined in sql", ErrTenantJoin, match[1]))
+					return false
+				}
+			}
+			continue
+		}
+
+		// the ON of a nested join is added to every relationship of it
+		scoped := 0
+		for _, relation := range relations {
+			if relation.FieldSchema.LookUpField(TenantColumn) != nil {
+				scoped++
+			}
+		}
+		if scoped == 0 {
+			continue
+		}
+		if scoped != len(relations) {
+			_ = tx.AddError(fmt.Errorf("%w: %s has tables without %s", ErrTenantJoin, join.Name, TenantColumn))
+			return false
+		}
+
+		on := clause.Where{}
+		if join.On != nil {
+			on.Exprs = append(on.Exprs, join.On.Exprs...)
+		}
+		// the current table of the ON is the alias of the joined relationship
+		on.Exprs = append(on.Exprs, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: TenantColumn}, Value: tenantId})
+		tx.Statement.Joins[i].On = &on
+	}
+	return true
+}
+
+// joinRelations resolves a join like gorm does, a relationship such as "Wallet"
+// or nested relationships such as "Wallet.User", it is nil for joins written in sql
+func joinRelations(modelSchema *schema.Schema, name string) []*schema.Relationship {
+	if modelSchema == nil {
+		return nil
+	}
+	if relation, ok := modelSchema.Relationships.Relations[name]; ok {
+		return []*schema.Relationship{relation}
+	}
+
+	var relations []*schema.Relationship
+	current := modelSchema.Relationships.Relations
+	for _, part := range strings.Split(name, ".") {
+		relation, ok := current[part]
+		if !ok {
+			return nil
+		}
+		relations = append(relations, relation)
+		current = relation.FieldSchema.Relationships.Relations
+	}
+	if len(relations) < 2 {
+		return nil
+	}
+	return relations
+}
+
+// update keeps rows from moving to another tenant and runs the missing where
+// check of gorm before the tenant condition would satisfy it
+func (p *TenantPlugin) update(tx *gorm.DB) {
+	if !checkConditions(tx) {
+		return
+	}
+	tx.Statement.Omits = append(tx.Statement.Omits, TenantColumn)
+	p.filter(tx)
+}
+
+func (p *TenantPlugin) delete(tx *gorm.DB) {
+	if checkConditions(tx) {
+		p.filter(tx)
+	}
+}
+
+func (p *TenantPlugin) create(tx *gorm.DB) {
+	tenantId, ok := p.tenant(tx)
+	if !ok || !p.hasTenantColumn(tx) {
+		return
+	}
+	if c, ok := tx.Statement.Clauses["ON CONFLICT"]; ok {
+		if onConflict, ok := c.Expression.(clause.OnConflict); ok && (onConflict.UpdateAll || len(onConflict.DoUpdates) > 0) {
+			_ = tx.AddError(ErrTenantUpsert)
+			return
+		}
+	}
+
+	var field *schema.Field
+	if tx.Statement.Schema != nil {
+		field = tx.Statement.Schema.LookUpField(TenantColumn)
+	}
+	assign := func(value reflect.Value) {
+		switch {
+		case value.Kind() == reflect.Map:
+			current := value.MapIndex(reflect.ValueOf(TenantColumn))
+			if current.IsValid() && fmt.Sprint(current.Interface()) != "" && fmt.Sprint(current.Interface()) != tenantId {
+				_ = tx.AddError(fmt.Errorf("%w: %q is not %q", ErrTenantMismatch, current.Interface(), tenantId))
+				return
+			}
+			value.SetMapIndex(reflect.ValueOf(TenantColumn), reflect.ValueOf(tenantId))
+		case value.Kind() == reflect.Struct && field != nil:
+			current, zero := field.ValueOf(tx.Statement.Context, value)
+			if !zero && current != tenantId {
+				_ = tx.AddError(fmt.Errorf("%w: %q is not %q", ErrTenantMismatch, current, tenantId))
+				return
+			}
+			if err := field.Set(tx.Statement.Context, value, tenantId); err != nil {
+				_ = tx.AddError(err)
+			}
+		}
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(tx.Statement.Dest))
+	if value.Kind() != reflect.Map && !(value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Map) {
+		value = tx.Statement.ReflectValue
+	}
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			assign(reflect.Indirect(value.Index(i)))
+		}
+	default:
+		assign(value)
+	}
+}
+
+// groupConditions puts the conditions of the statement in parentheses so an OR
+// in them can not escape a condition added after
+func groupConditions(tx *gorm.DB) {
+	if c, ok := tx.Statement.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 1 {
+			where.Exprs = []clause.Expression{clause.And(where.Exprs...)}
+			c.Expression = where
+			tx.Statement.Clauses["WHERE"] = c
+		}
+	}
+}
+
+// checkConditions fails an update or delete without conditions like gorm
+// does, it must run before a plugin adds its own condition
+func checkConditions(tx *gorm.DB) bool {
+	if tx.Error != nil || tx.Statement.SQL.Len() > 0 || tx.AllowGlobalUpdate {
+		return true
+	}
+	if _, ok := tx.Statement.Clauses["WHERE"]; ok {
+		return true
+	}
+	if tx.Statement.Schema != nil && tx.Statement.ReflectValue.IsValid() {
+		_, values := schema.GetIdentityFieldValuesMap(tx.Statement.Context, tx.Statement.ReflectValue, tx.Statement.Schema.PrimaryFields)
+		if len(values) > 0 {
+			return true
+		}
+	}
+	_ = tx.AddError(gorm.ErrMissingWhereClause)
+	return false
+}
@@ -4,11 +4,20 @@ import "gorm.io/gorm"
 
 type Todo struct {
 	gorm.Model
-	UserId      string `gorm:"column:user_id;"json:"user_id,omitempty"`
-	Title       string `gorm:"column:title;"json:"title,omitempty"`
-	Description string `gorm:"column:description;"json:"description,omitempty"`
+	UserId      string `gorm:"column:user_id;" json:"user_id,omitempty"`
+	Title       string `gorm:"column:title;" json:"title,omitempty"`
+	Description string `gorm:"column:description;" json:"description,omitempty"`
+	TenantId    string `gorm:"column:tenant_id" json:"-"`
 }
 
 func (t *Todo) TableName() string {
 	return "todos"
 }
+
+func (t *Todo) ReadScope(actor Actor) func(db *gorm.DB) *gorm.DB {
+	return OwnedByActor("user_id", actor)
+}
+
+func (t *Todo) CanWrite(actor Actor) bool {
+	return actor.Admin || t.UserId == actor.UserId
+}
@@ -0,0 +1,151 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const tracingName = "habibiiberahim/belajar-golang-gorm"
+
+type tracingStart struct {
+	span   trace.Span
+	begin  time.Time
+	parent context.Context
+}
+
+// TracingPlugin opens an OpenTelemetry span around every statement and records
+// its latency in the db.client.operation.duration histogram, failed statements
+// are counted in db.client.operation.errors. Install it with db.Use.
+type TracingPlugin struct {
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+}
+
+// NewTracingPlugin uses the global providers of otel when a provider is nil
+func NewTracingPlugin(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) (*TracingPlugin, error) {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+
+	meter := meterProvider.Meter(tracingName)
+	duration, err := meter.Float64Histogram("db.client.operation.duration",
+		metric.WithDescription("Duration of database statements"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	errorCount, err := meter.Int64Counter("db.client.operation.errors",
+		metric.WithDescription("Number of failed database statements"),
+		metric.WithUnit("{error}"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &TracingPlugin{
+		tracer:   tracerProvider.Tracer(tracingName),
+		duration: duration,
+		errors:   errorCount,
+	}, nil
+}
+
+func (p *TracingPlugin) Name() string {
+	return "otel_tracing"
+}
+
+func (p *TracingPlugin) Initialize(db *gorm.DB) error {
+	return registerStatementCallbacks(db, "otel", p.before, p.after)
+}
+
+// registerStatementCallbacks wraps the statement of every processor with a
+// before and after callback, the operation is create, select, update, delete,
+// row or raw
+func registerStatementCallbacks(db *gorm.DB, name string, before func(operation string) func(tx *gorm.DB), after func(operation string) func(tx *gorm.DB)) error {
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Create().Before("gorm:create").Register(name+":before_create", before("create")),
+		callback.Create().After("gorm:create").Register(name+":after_create", after("create")),
+		callback.Query().Before("gorm:query").Register(name+":before_query", before("select")),
+		callback.Query().After("gorm:query").Register(name+":after_query", after("select")),
+		callback.Update().Before("gorm:update").Register(name+":before_update", before("update")),
+		callback.Update().After("gorm:update").Register(name+":after_update", after("update")),
+		callback.Delete().Before("gorm:delete").Register(name+":before_delete", before("delete")),
+		callback.Delete().After("gorm:delete").Register(name+":after_delete", after("delete")),
+		callback.Row().Before("gorm:row").Register(name+":before_row", before("row")),
+		callback.Row().After("gorm:row").Register(name+":after_row", after("row")),
+		callback.Raw().Before("gorm:raw").Register(name+":before_raw", before("raw")),
+		callback.Raw().After("gorm:raw").Register(name+":after_raw", after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *TracingPlugin) before(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx, span := p.tracer.Start(tx.Statement.Context, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient))
+		tx.InstanceSet("otel:start", tracingStart{span: span, begin: time.Now(), parent: tx.Statement.Context})
+		// statements run by the callbacks, like preloads and hooks, become children of the span
+		tx.Statement.Context = ctx
+	}
+}
+
+func (p *TracingPlugin) after(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet("otel:start")
+		if !ok {
+			return
+		}
+		start := value.(tracingStart)
+		elapsed := time.Since(start.begin)
+
+		attrs := []attribute.KeyValue{
+			attribute.String("db.system", tx.Dialector.Name()),
+			attribute.String("db.operation.name", operation),
+			attribute.String("db.collection.name", tx.Statement.Table),
+		}
+		start.span.SetAttributes(attrs...)
+		start.span.SetAttributes(
+			attribute.String("db.query.text", SanitizeSQL(tx.Statement.SQL.String())),
+			attribute.Int64("db.rows_affected", tx.RowsAffected),
+		)
+
+		failed := tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound)
+		if failed {
+			start.span.RecordError(tx.Error)
+			start.span.SetStatus(codes.Error, tx.Error.Error())
+			p.errors.Add(tx.Statement.Context, 1, metric.WithAttributes(attrs...))
+		}
+		p.duration.Record(tx.Statement.Context, elapsed.Seconds(), metric.WithAttributes(attrs...))
+		start.span.End()
+		tx.Statement.Context = start.parent
+	}
+}
+
+var (
+	sqlStringLiteral  = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'`)
+	sqlNumericLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	sqlWhitespace     = regexp.MustCompile(`\s+`)
+)
+
+// SanitizeSQL replaces the string and number literals written in the sql with ?,
+// values sent as bind variables never appear in the sql
+func SanitizeSQL(sql string) string {
+	sql = sqlStringLiteral.ReplaceAllString(sql, "?")
+	sql = sqlNumericLiteral.ReplaceAllString(sql, "?")
+	return strings.TrimSpace(sqlWhitespace.ReplaceAllString(sql, " "))
+}
@@ -7,38 +7,59 @@ import (
 
 type User struct {
 	ID           string    `gorm:"primary_key; column:id;<-:create" json:"id,omitempty"`
-	Password     string    `gorm:"column:password" json:"password,omitempty"`
-	Name         Name      `gorm:"embedded"`
+	Password     Secret    `gorm:"column:password" json:"password,omitempty"`
+	Name         Name      `gorm:"embedded" json:"name"`
 	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime;<-:create" json:"created_at"`
 	UpdatedAt    time.Time `gorm:"column:updated_at;autoCreateTime" json:"updated_at"`
-	Information  string    `gorm:"-"`
-	Wallet       Wallet    `gorm:"foreignKey:user_id;references:id"`
-	Addresses    []Address `gorm:"foreignKey:user_id;references:id"`
-	LikeProducts []Product `gorm:"many2many:user_like_product;foreignKey:id;joinForeignKey:user_id;references:id;joinReferences:product_ide"`
+	TenantId     string    `gorm:"column:tenant_id" json:"-"`
+	Information  string    `gorm:"-" json:"information,omitempty"`
+	Wallet       Wallet    `gorm:"foreignKey:user_id;references:id" json:"wallet"`
+	Addresses    []Address `gorm:"foreignKey:user_id;references:id" json:"addresses,omitempty"`
+	LikeProducts []Product `gorm:"many2many:user_like_product;foreignKey:id;joinForeignKey:user_id;references:id;joinReferences:product_id" json:"like_products,omitempty"`
 }
 
+// Name is encrypted, the first and last name deterministically so they can be
+// looked up with EncryptedEquals, names can not be searched or sorted
 type Name struct {
-	FirstName  string `gorm:"column:first_name"`
-	MiddleName string `gorm:"column:middle_name"`
-	LastName   string `gorm:"column:last_name"`
+	FirstName  string `gorm:"column:first_name;size:512;serializer:encrypted;deterministic" json:"first_name"`
+	MiddleName string `gorm:"column:middle_name;size:512;serializer:encrypted" json:"middle_name,omitempty"`
+	LastName   string `gorm:"column:last_name;size:512;serializer:encrypted;deterministic" json:"last_name,omitempty"`
 }
 
 func (u *User) TableName() string {
 	return "users"
 }
 
+// ReadScope lets users read themselves and admins every user
+func (u *User) ReadScope(actor Actor) func(db *gorm.DB) *gorm.DB {
+	return OwnedByActor("id", actor)
+}
+
+func (u *User) CanWrite(actor Actor) bool {
+	return actor.Admin || u.ID == actor.UserId
+}
+
 type UserLog struct {
 	ID        int    `gorm:"primary_key;column:id;autoIncrement" json:"id,omitempty"`
 	UserId    string `gorm:"column:user_id" json:"user_id,omitempty"`
 	Action    string `gorm:"column:action" json:"action,omitempty"`
 	CreatedAt int64  `gorm:"column:created_at;autoCreateTime:milli;" json:"created_at"`
 	UpdatedAt int64  `gorm:"column:updated_at;autoCreateTime:milli;autoUpdateTime:milli" json:"updated_at"`
+	TenantId  string `gorm:"column:tenant_id" json:"-"`
 }
 
 func (ul *UserLog) TableName() string {
 	return "user_logs"
 }
 
+func (ul *UserLog) ReadScope(actor Actor) func(db *gorm.DB) *gorm.DB {
+	return OwnedByActor("user_id", actor)
+}
+
+func (ul *UserLog) CanWrite(actor Actor) bool {
+	return actor.Admin || ul.UserId == actor.UserId
+}
+
 func (u *User) BeforeCreate(db *gorm.DB) error {
 	if u.ID == "" {
 		u.ID = "user-" + time.Now().Format("20230102110105")
@@ -0,0 +1,102 @@
+package belajar_golang_gorm
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+const DefaultLikeSource = "web"
+
+type likeSourceKey struct{}
+
+// UserLikeProduct is the join model between User.LikeProducts and Product.LikedByUsers,
+// it must be registered with SetupLikeJoinTable before the association is used
+type UserLikeProduct struct {
+	UserId    string    `gorm:"primary_key;column:user_id" json:"user_id"`
+	ProductId string    `gorm:"primary_key;column:product_id" json:"product_id"`
+	Source    string    `gorm:"column:source" json:"source"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	TenantId  string    `gorm:"column:tenant_id" json:"-"`
+
+	// productIds are the products whose likes are removed by the running delete
+	productIds []string
+}
+
+func (ulp *UserLikeProduct) TableName() string {
+	return "user_like_product"
+}
+
+func (ulp *UserLikeProduct) BeforeCreate(db *gorm.DB) error {
+	if ulp.Source == "" {
+		ulp.Source = LikeSourceFromContext(db.Statement.Context)
+	}
+	return nil
+}
+
+// AfterCreate keeps products.like_count in sync for likes created through the
+// LikedByUsers or LikeProducts association as well as through LikeService.
+// The count is rebuilt instead of incremented because a like ignored by
+// ON CONFLICT DO NOTHING still runs the hook.
+func (ulp *UserLikeProduct) AfterCreate(db *gorm.DB) error {
+	return recountProductLikes(db, []string{ulp.ProductId})
+}
+
+// BeforeDelete remembers the products of the likes about to be deleted, the
+// association deletes a zero UserLikeProduct with the join keys as conditions
+func (ulp *UserLikeProduct) BeforeDelete(db *gorm.DB) error {
+	query := db.Model(&UserLikeProduct{})
+	if where, ok := db.Statement.Clauses["WHERE"]; ok {
+		query = query.Clauses(where.Expression)
+	}
+	if ulp.UserId != "" {
+		query = query.Where("user_id = ?", ulp.UserId)
+	}
+	if ulp.ProductId != "" {
+		query = query.Where("product_id = ?", ulp.ProductId)
+	}
+	return query.Distinct().Pluck("product_id", &ulp.productIds).Error
+}
+
+func (ulp *UserLikeProduct) AfterDelete(db *gorm.DB) error {
+	return recountProductLikes(db, ulp.productIds)
+}
+
+func recountProductLikes(db *gorm.DB, productIds []string) error {
+	if len(productIds) == 0 {
+		return nil
+	}
+	return db.Model(&Product{}).Where("id IN ?", productIds).
+		UpdateColumn("like_count", gorm.Expr("(select count(*) from user_like_product "+
+			"where user_like_product.product_id = products.id "+
+			"AND user_like_product.tenant_id = products.tenant_id)")).Error
+}
+
+func SetupLikeJoinTable(db *gorm.DB) error {
+	err := db.SetupJoinTable(&User{}, "LikeProducts", &UserLikeProduct{})
+	if err != nil {
+		return err
+	}
+	return db.SetupJoinTable(&Product{}, "LikedByUsers", &UserLikeProduct{})
+}
+
+// WithLikeSource sets the source stored with likes created using the returned context,
+// ex: db.WithContext(WithLikeSource(ctx, "mobile")).Model(&product).Association("LikedByUsers").Append(&user)
+func WithLikeSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, likeSourceKey{}, source)
+}
+
+func LikeSourceFromContext(ctx context.Context) string {
+	if ctx != nil {
+		if source, ok := ctx.Value(likeSourceKey{}).(string); ok && source != "" {
+			return source
+		}
+	}
+	return DefaultLikeSource
+}
+
+func LikedInLastDays(days int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_like_product.created_at >= ?", time.Now().AddDate(0, 0, -days))
+	}
+}
@@ -0,0 +1,75 @@
+package belajar_golang_gorm
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// validator collects the first error message of every invalid field, the keys
+// use the json names of the fields
+type validator map[string]string
+
+func (v validator) required(field string, value string) {
+	if strings.TrimSpace(value) == "" {
+		v[field] = "is required"
+	}
+}
+
+func (v validator) maxLength(field string, value string, max int) {
+	if _, ok := v[field]; !ok && utf8.RuneCountInString(value) > max {
+		v[field] = "must be at most " + strconv.Itoa(max) + " characters"
+	}
+}
+
+func (v validator) minLength(field string, value string, min int) {
+	if _, ok := v[field]; !ok && utf8.RuneCountInString(value) < min {
+		v[field] = "must be at least " + strconv.Itoa(min) + " characters"
+	}
+}
+
+func (v validator) notNegative(field string, value int64) {
+	if value < 0 {
+		v[field] = "must not be negative"
+	}
+}
+
+func ValidateUser(user *User) map[string]string {
+	v := validator{}
+	v.maxLength("id", user.ID, 100)
+	v.required("password", user.Password.Reveal())
+	v.minLength("password", user.Password.Reveal(), 6)
+	v.maxLength("password", user.Password.Reveal(), 100)
+	v.required("name.first_name", user.Name.FirstName)
+	v.maxLength("name.first_name", user.Name.FirstName, 100)
+	v.maxLength("name.middle_name", user.Name.MiddleName, 100)
+	v.maxLength("name.last_name", user.Name.LastName, 100)
+	return v
+}
+
+func ValidateWallet(wallet *Wallet) map[string]string {
+	v := validator{}
+	v.required("id", wallet.ID)
+	v.maxLength("id", wallet.ID, 100)
+	v.required("user_id", wallet.UserId)
+	v.notNegative("balance", wallet.Balance)
+	return v
+}
+
+func ValidateTodo(todo *Todo) map[string]string {
+	v := validator{}
+	v.required("user_id", todo.UserId)
+	v.required("title", todo.Title)
+	v.maxLength("title", todo.Title, 100)
+	return v
+}
+
+func ValidateProduct(product *Product) map[string]string {
+	v := validator{}
+	v.required("id", product.ID)
+	v.maxLength("id", product.ID, 100)
+	v.required("name", product.Name)
+	v.maxLength("name", product.Name, 100)
+	v.notNegative("price", product.Price)
+	return v
+}
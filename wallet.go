@@ -1,16 +1,29 @@
 package belajar_golang_gorm
 
-import "time"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type Wallet struct {
-	ID        string    `gorm:"primary_key;column:id"`
-	UserId    string    `gorm:"column:user_id"`
-	Balance   int64     `gorm:"column:balance"`
-	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
-	UpdatedAt time.Time `gorm:"column:created_at;autoCreateTime;autoUpdateTime"`
-	User      *User     `gorm:"foreignKey:user_id;references:id"`
+	ID        string    `gorm:"primary_key;column:id" json:"id"`
+	UserId    string    `gorm:"column:user_id" json:"user_id"`
+	Balance   int64     `gorm:"column:balance" json:"balance"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime" json:"updated_at"`
+	TenantId  string    `gorm:"column:tenant_id" json:"-"`
+	User      *User     `gorm:"foreignKey:user_id;references:id" json:"user,omitempty"`
 }
 
 func (w *Wallet) TableName() string {
 	return "wallets"
 }
+
+// ReadScope lets users read their own wallet and admins every wallet
+func (w *Wallet) ReadScope(actor Actor) func(db *gorm.DB) *gorm.DB {
+	return OwnedByActor("user_id", actor)
+}
+
+func (w *Wallet) CanWrite(actor Actor) bool {
+	return actor.Admin || w.UserId == actor.UserId
+}
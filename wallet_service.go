@@ -0,0 +1,80 @@
+package belajar_golang_gorm
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidAmount       = errors.New("amount must be positive")
+	ErrSameWallet          = errors.New("can not transfer to the same wallet")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+type WalletService struct {
+	db *gorm.DB
+}
+
+func NewWalletService(db *gorm.DB) *WalletService {
+	return &WalletService{db: db}
+}
+
+func (s *WalletService) Balance(walletId string) (Wallet, error) {
+	var wallet Wallet
+	err := s.db.Take(&wallet, "id = ?", walletId).Error
+	return wallet, err
+}
+
+// Transfer moves amount from one wallet to another and returns both wallets after the transfer
+func (s *WalletService) Transfer(fromWalletId string, toWalletId string, amount int64) (Wallet, Wallet, error) {
+	var from, to Wallet
+	if amount <= 0 {
+		return from, to, ErrInvalidAmount
+	}
+	if fromWalletId == toWalletId {
+		return from, to, ErrSameWallet
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// lock both wallets ordered by id so concurrent transfers can not deadlock
+		var wallets []Wallet
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", []string{fromWalletId, toWalletId}).
+			Order("id asc").
+			Find(&wallets).Error
+		if err != nil {
+			return err
+		}
+		if len(wallets) != 2 {
+			return gorm.ErrRecordNotFound
+		}
+		for _, wallet := range wallets {
+			if wallet.ID == fromWalletId {
+				from = wallet
+			} else {
+				to = wallet
+			}
+		}
+
+		if from.Balance < amount {
+			return ErrInsufficientBalance
+		}
+
+		err = tx.Model(&from).Update("balance", gorm.Expr("balance - ?", amount)).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&to).Update("balance", gorm.Expr("balance + ?", amount)).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Take(&from, "id = ?", fromWalletId).Error
+		if err != nil {
+			return err
+		}
+		return tx.Take(&to, "id = ?", toWalletId).Error
+	})
+	return from, to, err
+}